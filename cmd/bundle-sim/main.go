@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/image"
+	Scenario "deniable-im/im-sim/pkg/simulation/scenario"
+)
+
+// Usage: bundle-sim [-scenario file] [-build] save|load|verify bundle.tar
+func main() {
+	scenarioPath := flag.String("scenario", "", "JSON scenario whose images are bundled")
+	build := flag.Bool("build", false, "Build the images before saving")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: bundle-sim [flags] save|load|verify <bundle.tar>")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	command, path := flag.Arg(0), flag.Arg(1)
+
+	scenario := Scenario.DefaultDenimScenario()
+	if *scenarioPath != "" {
+		var err error
+		scenario, err = Scenario.Load(*scenarioPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	dockerClient, err := client.NewClient(nil)
+	if err != nil {
+		panic(err)
+	}
+	defer dockerClient.Close()
+
+	var images []image.BundleImage
+	switch command {
+	case "save":
+		if *build {
+			if err := scenario.BuildImages(dockerClient, false); err != nil {
+				panic(err)
+			}
+		}
+		images, err = image.SaveBundle(dockerClient, scenario.Images(), path)
+	case "load":
+		images, err = image.LoadBundle(dockerClient, path)
+	case "verify":
+		images, err = image.VerifyBundle(dockerClient, path, scenario.Images())
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %v\n", command)
+		os.Exit(2)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	for _, image := range images {
+		fmt.Printf("%v\t%v\n", image.Ref, image.ID)
+	}
+}
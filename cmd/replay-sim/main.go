@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/container"
+	Scenario "deniable-im/im-sim/pkg/simulation/scenario"
+	SimLogger "deniable-im/im-sim/pkg/simulation/simulator/sim_logger"
+)
+
+func main() {
+	manifestPath := flag.String("manifest", "", "manifest.json of the run to replay")
+	force := flag.Bool("force", false, "Replay even if the local images differ from the manifest")
+	population := flag.String("population", "", "Only write the regenerated population to this file")
+	flag.Parse()
+
+	if *manifestPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	manifest, err := Scenario.LoadManifest(*manifestPath)
+	if err != nil {
+		panic(err)
+	}
+
+	if *population != "" {
+		if err := writePopulation(manifest.Scenario, *population); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	dockerClient, err := client.NewClient(nil)
+	if err != nil {
+		panic(err)
+	}
+	defer dockerClient.Close()
+
+	if err := manifest.CheckImages(dockerClient); err != nil {
+		if !*force {
+			panic(err)
+		}
+		fmt.Println(err)
+	}
+
+	result, err := manifest.Replay(dockerClient)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Replay of %v written to %v\n", manifest.RunID, result.Dir)
+}
+
+// Population on placeholder containers, comparable with the users.json of the original run
+func writePopulation(scenario Scenario.Scenario, path string) error {
+	placeholders := make([]*container.Container, scenario.UserCount)
+	for i := range placeholders {
+		placeholders[i] = &container.Container{}
+	}
+
+	users := scenario.Population(placeholders)
+	info := make([]SimLogger.UserInfo, len(users))
+	for i, user := range users {
+		info[i].User = *user.User
+		info[i].Behavior = user.Behavior
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
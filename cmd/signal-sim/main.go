@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"net"
 	"time"
 
 	dockerTypes "github.com/docker/docker/api/types"
@@ -13,6 +14,8 @@ import (
 	"deniable-im/im-sim/pkg/client"
 	"deniable-im/im-sim/pkg/container"
 	"deniable-im/im-sim/pkg/image"
+	"deniable-im/im-sim/pkg/labels"
+	"deniable-im/im-sim/pkg/namespace"
 	"deniable-im/im-sim/pkg/network"
 	Behavior "deniable-im/im-sim/pkg/simulation/behavior"
 	Simulator "deniable-im/im-sim/pkg/simulation/simulator"
@@ -21,12 +24,27 @@ import (
 )
 
 func main() {
-	dockerClient, err := client.NewClient()
+	dockerClient, err := client.NewClient(nil)
 	if err != nil {
 		panic(err)
 	}
 	defer dockerClient.Close()
 
+	readyTimeout := 120 * time.Second
+
+	runID := labels.NewRunID()
+	ns, err := namespace.New(dockerClient, runID)
+	if err != nil {
+		panic(err)
+	}
+	// Runs during a panic too, the run's containers and networks are left for stop-sim
+	defer func() {
+		if err := ns.Release(); err != nil {
+			fmt.Println(err)
+		}
+	}()
+	fmt.Printf("Run %v in namespace %d\n", runID, ns.Index)
+
 	// Build redis image
 	_, err = image.NewImage(dockerClient, "./cmd/signal-sim/", &image.Options{
 		PullOpt: &image.PullOptions{
@@ -43,6 +61,7 @@ func main() {
 			Dockerfile: "Dockerfile.postgres",
 			Tags:       []string{"im-postgres"},
 		},
+		Labels: labels.Image(labels.RoleDB),
 	})
 	if err != nil {
 		panic(err)
@@ -54,6 +73,7 @@ func main() {
 			Dockerfile: "Dockerfile.server",
 			Tags:       []string{"im-server"},
 		},
+		Labels: labels.Image(labels.RoleServer),
 	})
 	if err != nil {
 		panic(err)
@@ -65,46 +85,48 @@ func main() {
 			Dockerfile: "Dockerfile.client",
 			Tags:       []string{"im-client"},
 		},
+		Labels: labels.Image(labels.RoleClient),
 	})
 	if err != nil {
 		panic(err)
 	}
 
 	// Create network for DB, cache and server
-	networkBackend := network.NewNetwork(dockerClient, "backend", network.Options{
-		Driver: "bridge",
+	networkBackend, err := network.NewNetwork(dockerClient, ns.Name("backend"), network.Options{
+		Profile: network.ProfileBridge,
+		Labels:  ns.Labels(labels.RoleNetwork),
 	})
+	if err != nil {
+		panic(err)
+	}
 
 	// Create network that supports 2046 IPs
 	networkOptions := network.Options{
-		Driver: "macvlan",
-		IPAM: &dockerNetwork.IPAM{
-			Config: []dockerNetwork.IPAMConfig{
-				{
-					Subnet:  "10.10.240.0/20",
-					IPRange: "10.10.248.0/21",
-					Gateway: "10.10.248.1",
-				},
-			},
-		},
+		Profile: network.ProfileMacvlan,
+		Labels:  ns.Labels(labels.RoleNetwork),
+		IPAM:    ns.IPAM(),
 	}
 
 	// Create network
-	networkIMvlan := network.NewNetwork(dockerClient, "IMvlan", networkOptions)
+	networkIMvlan, err := network.NewNetwork(dockerClient, ns.Name("IMvlan"), networkOptions)
+	if err != nil {
+		panic(err)
+	}
 
 	// Setup redis
 	cache, err := container.NewContainer(
 		dockerClient,
 		"redis:latest",
-		"im-redis",
+		ns.Name("im-redis"),
 		&container.Options{
+			Labels:      ns.Labels(labels.RoleCache),
 			Connections: network.NewConnections(networkBackend),
 			HostConfig: &dockerContainer.HostConfig{
 				Runtime: "crun",
 			},
 			NetworkConfig: &dockerNetwork.NetworkingConfig{
 				EndpointsConfig: map[string]*dockerNetwork.EndpointSettings{
-					"backend": {
+					networkBackend.Name: {
 						Aliases: []string{"redis"},
 					},
 				},
@@ -118,19 +140,24 @@ func main() {
 		panic(err)
 	}
 
+	if err := container.WaitFor(dockerClient.Ctx, cache.Name, cache.RedisProbe(), readyTimeout); err != nil {
+		panic(err)
+	}
+
 	// Setup DB
 	db, err := container.NewContainer(
 		dockerClient,
 		"im-postgres",
-		"im-postgres",
+		ns.Name("im-postgres"),
 		&container.Options{
+			Labels:      ns.Labels(labels.RoleDB),
 			Connections: network.NewConnections(networkBackend),
 			HostConfig: &dockerContainer.HostConfig{
 				Runtime: "crun",
 			},
 			NetworkConfig: &dockerNetwork.NetworkingConfig{
 				EndpointsConfig: map[string]*dockerNetwork.EndpointSettings{
-					"backend": {
+					networkBackend.Name: {
 						Aliases: []string{"db"},
 					},
 				},
@@ -144,13 +171,18 @@ func main() {
 		panic(err)
 	}
 
+	if err := container.WaitFor(dockerClient.Ctx, db.Name, db.PostgresProbe(), readyTimeout); err != nil {
+		panic(err)
+	}
+
 	// Setup server
-	serverIP := "10.10.248.2"
+	serverIP := ns.ServerIP()
 	server, err := container.NewContainer(
 		dockerClient,
 		"im-server",
-		"im-server",
+		ns.Name("im-server"),
 		&container.Options{
+			Labels: ns.Labels(labels.RoleServer),
 			Connections: network.NewConnections(
 				networkBackend,
 				network.NewAddrMapping(networkIMvlan, serverIP),
@@ -160,7 +192,7 @@ func main() {
 			},
 			NetworkConfig: &dockerNetwork.NetworkingConfig{
 				EndpointsConfig: map[string]*dockerNetwork.EndpointSettings{
-					"IMvlan": {
+					networkIMvlan.Name: {
 						Aliases: []string{"server"},
 					},
 				},
@@ -174,13 +206,25 @@ func main() {
 		panic(err)
 	}
 
+	serverBackendIP, err := server.IPAddress(networkBackend.Name)
+	if err != nil {
+		panic(err)
+	}
+	tlsConfig, err := container.LoadTLSConfig("./cmd/signal-sim/cert/rootCA.crt", "server")
+	if err != nil {
+		fmt.Println(err)
+	}
+	if err := container.WaitFor(dockerClient.Ctx, server.Name, container.TLSProbe(net.JoinHostPort(serverBackendIP, "443"), tlsConfig), readyTimeout); err != nil {
+		panic(err)
+	}
+
 	// Setup clients
 	var images []types.Pair[string, string]
 	for i := range [100]int{} {
 		images = append(images,
 			types.Pair[string, string]{
 				Fst: "im-client",
-				Snd: fmt.Sprintf("im-client-%d", i),
+				Snd: ns.Name(fmt.Sprintf("im-client-%d", i)),
 			})
 	}
 
@@ -188,6 +232,8 @@ func main() {
 		dockerClient,
 		images,
 		&container.Options{
+			Labels:      ns.Labels(labels.RoleClient),
+			Env:         ns.ClientEnv(),
 			Connections: network.NewConnections(networkIMvlan),
 			HostConfig: &dockerContainer.HostConfig{
 				Runtime: "crun",
@@ -197,7 +243,7 @@ func main() {
 		panic(err)
 	}
 
-	reservedIP := []string{"10.10.248.2"}
+	reservedIP := []string{serverIP}
 	clientContainers, err = container.AssignIP(clientContainers, reservedIP, *networkIMvlan)
 	if err != nil {
 		panic(err)
@@ -205,9 +251,10 @@ func main() {
 
 	container.StartContainers(clientContainers)
 
-	time.Sleep(5 * time.Second) //IMPORTANT SLEEP FOR ARTHUR'S MACHINE
-
-	networkName := fmt.Sprintf("dm-%v", networkIMvlan.ID[:12])
+	networkName, err := networkIMvlan.CaptureInterface()
+	if err != nil {
+		panic(err)
+	}
 
 	r := rand.New(rand.NewSource(42069))
 	aliceUserType := Types.SimUser{ID: 1, Nickname: "alice", RegularContactList: []string{"2", "3"}}
@@ -224,6 +271,11 @@ func main() {
 
 	users := []*User.SimulatedUser{&simulatedAlice, &simulatedBob, &simulatedCharlie}
 	println("Starting simulation")
-	Simulator.SimulateTraffic(users, 45, networkName)
+	if _, err := Simulator.SimulateTraffic(users, 45, networkName, &Simulator.Options{
+		Infrastructure: map[string]*container.Container{"server": server, "db": db, "cache": cache},
+		StatsInterval:  10 * time.Second,
+	}); err != nil {
+		panic(err)
+	}
 
 }
@@ -1,48 +1,52 @@
 package main
 
 import (
-	"context"
+	"flag"
 	"fmt"
-	"sync"
 
-	"github.com/docker/docker/api/types"
-	containertypes "github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
+	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/container"
+	"deniable-im/im-sim/pkg/network"
 )
 
 func main() {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	runID := flag.String("run", "", "Only touch objects of this run ID, every simulator run if empty")
+	remove := flag.Bool("rm", false, "Remove containers and networks after stopping")
+	flag.Parse()
+
+	dockerClient, err := client.NewClient(nil)
 	if err != nil {
 		panic(err)
 	}
-	defer cli.Close()
+	defer dockerClient.Close()
 
-	containers, err := cli.ContainerList(ctx, containertypes.ListOptions{})
+	containers, err := container.ListContainers(dockerClient, *runID)
 	if err != nil {
 		panic(err)
 	}
 
-	// c1 := containers[:len(containers)]
-
-	var wg sync.WaitGroup
-	for i, container := range containers {
-		wg.Add(1)
+	for _, c := range containers {
+		fmt.Printf("Stopping container %v\n", c.Name)
+	}
 
-		go func(c types.Container) {
-			defer wg.Done()
+	if !*remove {
+		noWait := 0
+		if err := container.StopContainers(containers, &noWait); err != nil {
+			panic(err)
+		}
+		return
+	}
 
-			fmt.Printf("Stopping container - Running IMAGE: %v\n", container.Names)
-			noWait := 0
-			if err := cli.ContainerStop(ctx, container.ID, containertypes.StopOptions{Timeout: &noWait}); err != nil {
-				panic(err)
-			}
-		}(container)
+	networks, err := network.ListNetworks(dockerClient, *runID)
+	if err != nil {
+		panic(err)
+	}
 
-		if i%50 == 0 {
-			wg.Wait()
-		}
+	for _, n := range networks {
+		fmt.Printf("Removing network %v (%v)\n", n.Name, n.Options.Driver)
 	}
 
-	wg.Wait()
+	if err := container.RemoveAll(dockerClient, containers, networks); err != nil {
+		panic(err)
+	}
 }
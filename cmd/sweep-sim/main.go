@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"deniable-im/im-sim/pkg/client"
+	Sweep "deniable-im/im-sim/pkg/simulation/sweep"
+)
+
+func main() {
+	sweepPath := flag.String("sweep", "sweep.json", "JSON sweep with a base scenario and parameters")
+	parallel := flag.Int("parallel", 0, "Concurrent runs, overrides the sweep file if set")
+	noBuild := flag.Bool("no-build", false, "Use the local images, failing if any are missing")
+	flag.Parse()
+
+	sweep, err := Sweep.Load(*sweepPath)
+	if err != nil {
+		panic(err)
+	}
+	if *parallel > 0 {
+		sweep.Parallel = *parallel
+	}
+	sweep.NoBuild = sweep.NoBuild || *noBuild
+
+	dockerClient, err := client.NewClient(nil)
+	if err != nil {
+		panic(err)
+	}
+	defer dockerClient.Close()
+
+	index, err := sweep.Run(dockerClient)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, run := range index.Runs {
+		if run.Error != "" {
+			fmt.Printf("Run %d failed: %v\n", run.Number, run.Error)
+			continue
+		}
+		fmt.Printf("Run %d: %v delivered %d/%d\n", run.Number, run.Dir, run.Result.Delivery.Delivered, run.Result.Delivery.Sent)
+	}
+}
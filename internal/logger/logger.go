@@ -3,21 +3,34 @@ package logger
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 )
 
+type streamErrorDetail struct {
+	Message string `json:"message"`
+}
+
 type imageBuildStream struct {
-	Stream string `json:"stream"`
-	Error  string `json:"error"`
+	Stream      string            `json:"stream"`
+	Error       string            `json:"error"`
+	ErrorDetail streamErrorDetail `json:"errorDetail"`
+}
+
+type imagePullProgress struct {
+	Current int64 `json:"current"`
+	Total   int64 `json:"total"`
 }
 
 type imagePullStream struct {
-	Status         string `json:"status"`
-	ProgressDetail string `json:"progressDetail"`
-	Progress       string `json:"progress"`
-	Id             string `json:"id"`
+	Status         string            `json:"status"`
+	ProgressDetail imagePullProgress `json:"progressDetail"`
+	Progress       string            `json:"progress"`
+	Id             string            `json:"id"`
+	Error          string            `json:"error"`
+	ErrorDetail    streamErrorDetail `json:"errorDetail"`
 }
 
 type containerSliceStream struct {
@@ -27,24 +40,88 @@ type containerSliceStream struct {
 	Name   string `json:"name"`
 }
 
-func LogImageBuild(reader io.Reader) {
+// Number of output lines kept for a StreamError
+const streamTailLines = 20
+
+// Error reported by the Docker daemon in a build or pull stream
+type StreamError struct {
+	Step    string // Last build step or pull status before the error
+	Message string
+	Tail    []string // Last output lines before the error
+}
+
+func (err *StreamError) Error() string {
+	var builder strings.Builder
+	builder.WriteString(err.Message)
+	if err.Step != "" {
+		fmt.Fprintf(&builder, " (at %s)", err.Step)
+	}
+	for _, line := range err.Tail {
+		fmt.Fprintf(&builder, "\n\t%s", line)
+	}
+	return builder.String()
+}
+
+// Bounded list of the latest output lines
+type streamTail []string
+
+func (tail *streamTail) add(text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		*tail = append(*tail, line)
+		if len(*tail) > streamTailLines {
+			*tail = (*tail)[1:]
+		}
+	}
+}
+
+func streamErrorMessage(message string, detail streamErrorDetail) string {
+	if message != "" {
+		return message
+	}
+	return detail.Message
+}
+
+// Decodes the next message, skipping fields of unexpected type. Returns io.EOF at the end of the stream.
+func decodeStream(decoder *json.Decoder, msg any) error {
+	err := decoder.Decode(msg)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return nil
+	}
+	return err
+}
+
+func LogImageBuild(reader io.Reader) error {
 	decoder := json.NewDecoder(reader)
 
 	fmt.Print(HideCursor)
 	defer fmt.Print(ShowCursor)
 	handleForcedExit()
 
+	var step string
+	var tail streamTail
 	for {
 		var msg imageBuildStream
-		if err := decoder.Decode(&msg); err != nil {
+		if err := decodeStream(decoder, &msg); err != nil {
 			if err == io.EOF {
 				break
 			}
+			return fmt.Errorf("Failed to read build output: %w.", err)
+		}
+
+		if message := streamErrorMessage(msg.Error, msg.ErrorDetail); message != "" {
+			fmt.Print("\n")
+			return &StreamError{Step: step, Message: strings.TrimSpace(message), Tail: tail}
 		}
 
 		stream := msg.Stream
+		tail.add(stream)
 		if stream != "" {
 			if strings.HasPrefix(stream, "Step") {
+				step = strings.TrimSpace(stream)
 				fmt.Print(ClearEntireLine)
 				fmt.Print(PlumForeground.Set(fitTerminal(stream)))
 			} else if strings.HasPrefix(stream, "Successfully built") {
@@ -55,7 +132,7 @@ func LogImageBuild(reader io.Reader) {
 			} else if strings.Contains(stream, "--->") {
 				fmt.Print(MoveCursorDown)
 				fmt.Print(ClearEntireLine)
-				fmt.Printf(GreyForeground.Set(fitTerminal(stream)))
+				fmt.Print(GreyForeground.Set(fitTerminal(stream)))
 				fmt.Print(MoveCursorUp)
 				fmt.Print(MoveCursorUp)
 			} else {
@@ -63,9 +140,11 @@ func LogImageBuild(reader io.Reader) {
 			}
 		}
 	}
+
+	return nil
 }
 
-func LogImagePull(reader io.Reader) {
+func LogImagePull(reader io.Reader) error {
 	decoder := json.NewDecoder(reader)
 
 	fmt.Print(HideCursor)
@@ -73,15 +152,22 @@ func LogImagePull(reader io.Reader) {
 	handleForcedExit()
 
 	var imageName string
+	var tail streamTail
 	progressMap := make(map[string]string)
 	for {
 		var msg imagePullStream
-		if err := decoder.Decode(&msg); err != nil {
+		if err := decodeStream(decoder, &msg); err != nil {
 			if err == io.EOF {
 				break
 			}
+			return fmt.Errorf("Failed to read pull output: %w.", err)
 		}
 
+		if message := streamErrorMessage(msg.Error, msg.ErrorDetail); message != "" {
+			return &StreamError{Step: imageName, Message: strings.TrimSpace(message), Tail: tail}
+		}
+		tail.add(strings.TrimSpace(fmt.Sprintf("%s %s", msg.Id, msg.Status)))
+
 		if msg.Progress != "" {
 			for range progressMap {
 				fmt.Print(MoveCursorUp)
@@ -101,6 +187,37 @@ func LogImagePull(reader io.Reader) {
 	}
 
 	fmt.Print(BlueForeground.Set(fmt.Sprintf("Pulled: %s\n", imageName)))
+	return nil
+}
+
+func LogImageLoad(reader io.Reader) error {
+	decoder := json.NewDecoder(reader)
+
+	var tail streamTail
+	for {
+		var msg imageBuildStream
+		if err := decodeStream(decoder, &msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("Failed to read load output: %w.", err)
+		}
+
+		if message := streamErrorMessage(msg.Error, msg.ErrorDetail); message != "" {
+			return &StreamError{Message: strings.TrimSpace(message), Tail: tail}
+		}
+
+		if msg.Stream != "" {
+			tail.add(msg.Stream)
+			fmt.Print(BlueForeground.Set(msg.Stream))
+		}
+	}
+
+	return nil
+}
+
+func LogImageCached(string string) {
+	fmt.Printf("%s\n", BlueForeground.Set(string))
 }
 
 func LogContainerSlice(reader io.Reader) {
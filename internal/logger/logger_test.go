@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogImageBuildReportsFailingStep(t *testing.T) {
+	output := strings.Join([]string{
+		`{"stream":"Step 1/3 : FROM rust:1-alpine3.20\n"}`,
+		`{"stream":" ---> 5d1c1a2b3c4d\n"}`,
+		`{"stream":"Step 2/3 : RUN cargo build --release\n"}`,
+		`{"stream":"error[E0425]: cannot find value\n"}`,
+		`{"errorDetail":{"code":101,"message":"The command '/bin/sh -c cargo build --release' returned a non-zero code: 101"},"error":"The command '/bin/sh -c cargo build --release' returned a non-zero code: 101"}`,
+	}, "\n")
+
+	err := LogImageBuild(strings.NewReader(output))
+
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) {
+		t.Fatalf("Expected a StreamError, got %v", err)
+	}
+	if streamErr.Step != "Step 2/3 : RUN cargo build --release" {
+		t.Errorf("Wrong step: %q", streamErr.Step)
+	}
+	if len(streamErr.Tail) == 0 || streamErr.Tail[len(streamErr.Tail)-1] != "error[E0425]: cannot find value" {
+		t.Errorf("Tail is missing the compiler error: %q", streamErr.Tail)
+	}
+}
+
+func TestLogImagePullSkipsProgressDetail(t *testing.T) {
+	output := `{"status":"Pulling from library/redis","id":"latest"}
+{"status":"Downloading","progressDetail":{"current":1024,"total":4096},"progress":"[==>   ]","id":"a1b2c3"}
+{"status":"Status: Downloaded newer image for redis:latest"}`
+
+	if err := LogImagePull(strings.NewReader(output)); err != nil {
+		t.Fatal(err)
+	}
+}
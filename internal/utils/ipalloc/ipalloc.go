@@ -0,0 +1,155 @@
+package ipalloc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+var (
+	ErrInvalid   = errors.New("Invalid IP configuration")
+	ErrExhausted = errors.New("IP range exhausted")
+)
+
+// Hands out the addresses of an IPv4 or IPv6 range in ascending order. The first and last
+// address of the subnet, the gateway and reserved addresses are never returned.
+type Allocator struct {
+	subnet   netip.Prefix
+	ipRange  netip.Prefix
+	next     netip.Addr
+	reserved map[netip.Addr]struct{}
+}
+
+// Parses a prefix that starts at its network address, e.g. "10.10.240.0/20" or "fd00:10:0:1::/64"
+func ParsePrefix(prefix string) (netip.Prefix, error) {
+	parsed, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	if parsed.Addr().Is4In6() || parsed.Addr().Zone() != "" {
+		return netip.Prefix{}, fmt.Errorf("%w: %s is neither plain IPv4 nor IPv6.", ErrInvalid, prefix)
+	}
+
+	if parsed != parsed.Masked() {
+		return netip.Prefix{}, fmt.Errorf("%w: %s has host bits set, expected %s.", ErrInvalid, prefix, parsed.Masked())
+	}
+
+	return parsed, nil
+}
+
+// Last address of the prefix, the broadcast address for IPv4
+func Last(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+	for i := prefix.Bits(); i < len(bytes)*8; i++ {
+		bytes[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// An empty ipRange allocates from the whole subnet, an empty gateway is not reserved
+func NewAllocator(subnet, ipRange, gateway string, reserved []string) (*Allocator, error) {
+	subnetPrefix, err := ParsePrefix(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	rangePrefix := subnetPrefix
+	if ipRange != "" {
+		rangePrefix, err = ParsePrefix(ipRange)
+		if err != nil {
+			return nil, err
+		}
+		if rangePrefix.Bits() < subnetPrefix.Bits() || !subnetPrefix.Contains(rangePrefix.Addr()) {
+			return nil, fmt.Errorf("%w: IP range %s is not within subnet %s.", ErrInvalid, rangePrefix, subnetPrefix)
+		}
+	}
+
+	allocator := &Allocator{
+		subnet:   subnetPrefix,
+		ipRange:  rangePrefix,
+		next:     rangePrefix.Addr(),
+		reserved: map[netip.Addr]struct{}{subnetPrefix.Addr(): {}, Last(subnetPrefix): {}},
+	}
+
+	if gateway != "" {
+		if err := allocator.reserve("gateway", gateway); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ip := range reserved {
+		if err := allocator.reserve("reserved address", ip); err != nil {
+			return nil, err
+		}
+	}
+
+	return allocator, nil
+}
+
+// Address family of the subnet
+func (allocator *Allocator) Is6() bool {
+	return allocator.subnet.Addr().Is6()
+}
+
+func (allocator *Allocator) reserve(kind string, ip string) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Errorf("%w: %s %w", ErrInvalid, kind, err)
+	}
+
+	if !allocator.subnet.Contains(addr) {
+		return fmt.Errorf("%w: %s %s is not within subnet %s.", ErrInvalid, kind, addr, allocator.subnet)
+	}
+
+	if addr == allocator.subnet.Addr() || addr == Last(allocator.subnet) {
+		return fmt.Errorf("%w: %s %s is the first or last address of %s.", ErrInvalid, kind, addr, allocator.subnet)
+	}
+
+	allocator.reserved[addr] = struct{}{}
+	return nil
+}
+
+// Lowest free address of the range
+func (allocator *Allocator) Next() (netip.Addr, error) {
+	for allocator.next.IsValid() && allocator.ipRange.Contains(allocator.next) {
+		addr := allocator.next
+		allocator.next = addr.Next()
+
+		if _, ok := allocator.reserved[addr]; !ok {
+			return addr, nil
+		}
+	}
+
+	return netip.Addr{}, fmt.Errorf("%w: %s.", ErrExhausted, allocator.ipRange)
+}
+
+// Number of addresses Next can still return, capped at math.MaxInt for large IPv6 ranges
+func (allocator *Allocator) Available() int {
+	if !allocator.next.IsValid() || !allocator.ipRange.Contains(allocator.next) {
+		return 0
+	}
+
+	hostBits := allocator.next.BitLen() - allocator.ipRange.Bits()
+	if hostBits >= 62 {
+		return math.MaxInt
+	}
+
+	count := int(low64(Last(allocator.ipRange))-low64(allocator.next)) + 1
+
+	for addr := range allocator.reserved {
+		if allocator.ipRange.Contains(addr) && addr.Compare(allocator.next) >= 0 {
+			count--
+		}
+	}
+	return count
+}
+
+// Lowest 64 bits of the address
+func low64(addr netip.Addr) uint64 {
+	bytes := addr.As16()
+	return binary.BigEndian.Uint64(bytes[8:])
+}
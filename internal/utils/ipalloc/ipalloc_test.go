@@ -0,0 +1,86 @@
+package ipalloc
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestAllocatorSkipsReservedAddresses(t *testing.T) {
+	allocator, err := NewAllocator("10.10.240.0/20", "10.10.255.248/29", "10.10.255.249", []string{"10.10.255.250"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if available := allocator.Available(); available != 5 {
+		t.Errorf("Expected 5 available addresses, got %d", available)
+	}
+
+	var got []string
+	for {
+		addr, err := allocator.Next()
+		if errors.Is(err, ErrExhausted) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, addr.String())
+	}
+
+	// .255 is the broadcast address of the subnet
+	expected := []string{"10.10.255.248", "10.10.255.251", "10.10.255.252", "10.10.255.253", "10.10.255.254"}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Address %d: expected %v, got %v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestAllocatorValidation(t *testing.T) {
+	for _, test := range []struct {
+		name, subnet, ipRange, gateway string
+	}{
+		{"host bits", "10.10.240.1/20", "", ""},
+		{"IPv4-mapped subnet", "::ffff:10.10.240.0/116", "", ""},
+		{"range outside subnet", "10.10.240.0/20", "10.11.248.0/21", ""},
+		{"range larger than subnet", "10.10.248.0/21", "10.10.240.0/20", ""},
+		{"gateway outside subnet", "10.10.240.0/20", "", "10.11.248.1"},
+		{"gateway is network address", "10.10.240.0/20", "", "10.10.240.0"},
+	} {
+		if _, err := NewAllocator(test.subnet, test.ipRange, test.gateway, nil); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", test.name, err)
+		}
+	}
+}
+
+func TestLast(t *testing.T) {
+	if last := Last(netip.MustParsePrefix("10.12.240.0/20")); last.String() != "10.12.255.255" {
+		t.Errorf("Expected 10.12.255.255, got %v", last)
+	}
+	if last := Last(netip.MustParsePrefix("fd00:10:0:2::/112")); last.String() != "fd00:10:0:2::ffff" {
+		t.Errorf("Expected fd00:10:0:2::ffff, got %v", last)
+	}
+}
+
+func TestAllocatorIPv6(t *testing.T) {
+	allocator, err := NewAllocator("fd00:10:0:2::/64", "fd00:10:0:2::/112", "fd00:10:0:2::1", []string{"fd00:10:0:2::2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if available := allocator.Available(); available != 65536-3 {
+		t.Errorf("Expected %d available addresses, got %d", 65536-3, available)
+	}
+
+	addr, err := allocator.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "fd00:10:0:2::3" {
+		t.Errorf("Expected fd00:10:0:2::3, got %v", addr)
+	}
+}
@@ -1,11 +1,13 @@
 package container
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/netip"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,13 +17,17 @@ import (
 
 	"deniable-im/im-sim/internal/logger"
 	"deniable-im/im-sim/internal/types"
-	"deniable-im/im-sim/internal/utils/ipv4"
+	"deniable-im/im-sim/internal/utils/ipalloc"
 	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/labels"
 	"deniable-im/im-sim/pkg/network"
 	"deniable-im/im-sim/pkg/process"
 )
 
-var ErrNoNetworks = errors.New("container not connected to any network")
+var (
+	ErrNoNetworks = errors.New("container not connected to any network")
+	ErrConflict   = errors.New("container name used by another run")
+)
 
 type Container struct {
 	Client  *client.Client
@@ -29,6 +35,7 @@ type Container struct {
 	Image   string
 	Name    string
 	Options *Options
+	Created bool // False if an existing container was reused
 }
 
 func NewContainer(client *client.Client, image string, name string, options *Options) (*Container, error) {
@@ -46,28 +53,38 @@ func NewContainer(client *client.Client, image string, name string, options *Opt
 		options.Platform,
 		name)
 	if err != nil {
-		if errors.Is(err, errdefs.Conflict(err)) {
-			// Container is already in use! Return it
+		if errdefs.IsConflict(err) {
+			// Container is already in use! Only reuse it if it belongs to the same run
 			id, err := GetIdByName(client, name)
 			if err != nil {
 				return nil, fmt.Errorf("Failed to create container conflict: %w.", err)
 			}
+
+			inspect, err := client.Cli.ContainerInspect(client.Ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to inspect conflicting container: %w.", err)
+			}
+
+			runID := options.Labels[labels.Run]
+			if runID == "" || inspect.Config == nil || inspect.Config.Labels[labels.Run] != runID {
+				return nil, fmt.Errorf("Failed to create container %s: %w.", name, ErrConflict)
+			}
 			return &Container{Client: client, ID: id, Image: image, Name: name, Options: options}, nil
 		} else {
 			return nil, fmt.Errorf("Failed to create container: %w.", err)
 		}
 	}
 
-	return &Container{Client: client, ID: res.ID, Image: image, Name: name, Options: options}, nil
+	return &Container{Client: client, ID: res.ID, Image: image, Name: name, Options: options, Created: true}, nil
 }
 
+// Containers are returned in the order of images. If options carry labels, each container is
+// labelled with its index as user ID, matching the IDs the simulation managers assign.
 func NewContainerSlice(client *client.Client, images []types.Pair[string, string], options *Options) ([]*Container, error) {
 	var wg sync.WaitGroup
 
-	const poolSize = 50
-	results := make(chan *Container, poolSize)
-
 	imagesLen := len(images)
+	containers := make([]*Container, imagesLen)
 	errc := make(chan error, imagesLen)
 
 	reader, writer := io.Pipe()
@@ -76,13 +93,16 @@ func NewContainerSlice(client *client.Client, images []types.Pair[string, string
 		logger.LogContainerSlice(reader)
 	}()
 
-	for _, image := range images {
+	for i, image := range images {
 		wg.Add(1)
 
-		go func(image types.Pair[string, string]) {
+		go func(i int, image types.Pair[string, string]) {
 			defer wg.Done()
 
 			options := options.DeepCopy()
+			if options.Labels != nil {
+				options.Labels = options.Labels.WithUser(strconv.Itoa(i))
+			}
 
 			container, err := NewContainer(client, image.Fst, image.Snd, options)
 			if err != nil {
@@ -90,7 +110,7 @@ func NewContainerSlice(client *client.Client, images []types.Pair[string, string
 				return
 			}
 
-			results <- container
+			containers[i] = container
 
 			log := fmt.Sprintf(
 				`{"status": "created", "total": %d, "image": "%s", "name": "%s"}`,
@@ -100,21 +120,15 @@ func NewContainerSlice(client *client.Client, images []types.Pair[string, string
 			writer.Write([]byte(log))
 
 			errc <- nil
-		}(image)
+		}(i, image)
 	}
 
 	go func() {
 		wg.Wait()
-		close(results)
 		close(errc)
 		writer.Close()
 	}()
 
-	var containers []*Container
-	for container := range results {
-		containers = append(containers, container)
-	}
-
 	for err := range errc {
 		if err != nil {
 			return nil, err
@@ -124,6 +138,32 @@ func NewContainerSlice(client *client.Client, images []types.Pair[string, string
 	return containers, nil
 }
 
+// Lists containers of one simulation run, or of every run if runID is empty
+func ListContainers(client *client.Client, runID string) ([]*Container, error) {
+	list, err := client.Cli.ContainerList(client.Ctx, dockerContainer.ListOptions{All: true, Filters: labels.Filter(runID)})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list containers: %w.", err)
+	}
+
+	var containers []*Container
+	for _, c := range list {
+		var name string
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+
+		containers = append(containers, &Container{
+			Client:  client,
+			ID:      c.ID,
+			Image:   c.Image,
+			Name:    name,
+			Options: &Options{Labels: c.Labels},
+		})
+	}
+
+	return containers, nil
+}
+
 func StartContainers(containers []*Container) {
 	var wg sync.WaitGroup
 
@@ -204,12 +244,17 @@ func (container *Container) NetworkConnect(network network.Network) error {
 		return fmt.Errorf("Container network connect inspect failed: %w.", err)
 	}
 
-	// Update IPv4 if assigned later
+	// Update addresses if assigned later
 	endPointSettings := container.Options.NetworkConfig.EndpointsConfig[network.Name]
 	conn := container.Options.Connections[network.Name]
-	if endPointSettings != nil && conn != nil && conn.IPv4 != nil {
+	if endPointSettings != nil && conn != nil && (conn.IPv4 != nil || conn.IPv6 != nil) {
 		endPointSettings.IPAMConfig = &dockerNetwork.EndpointIPAMConfig{}
-		endPointSettings.IPAMConfig.IPv4Address = *conn.IPv4
+		if conn.IPv4 != nil {
+			endPointSettings.IPAMConfig.IPv4Address = *conn.IPv4
+		}
+		if conn.IPv6 != nil {
+			endPointSettings.IPAMConfig.IPv6Address = *conn.IPv6
+		}
 	}
 
 	if err := container.Client.Cli.NetworkConnect(container.Client.Ctx, network.ID, container.ID, endPointSettings); err != nil {
@@ -273,39 +318,91 @@ func GetIdByName(client *client.Client, containerName string) (string, error) {
 	return "", fmt.Errorf("Container ID for %s not found", containerName)
 }
 
+// Assigns IPs of every IPAM config in container order, so a run always maps users to the same
+// addresses. Dual-stack networks give each container an IPv4 and an IPv6 address.
 func AssignIP(containers []*Container, reservedIP []string, net network.Network) ([]*Container, error) {
 	ipam := net.Options.IPAM
-	if len(ipam.Config) != 1 {
-		return nil, fmt.Errorf("Network assign ip need one IPAM config. Got %d.", len(ipam.Config))
+	if ipam == nil || len(ipam.Config) == 0 || len(ipam.Config) > 2 {
+		return nil, fmt.Errorf("Network assign ip needs one IPAM config, or two for dual-stack.")
 	}
 
-	gateway := ipam.Config[0].Gateway
-	IPRange := ipam.Config[0].IPRange
+	for _, config := range ipam.Config {
+		subnet, err := ipalloc.ParsePrefix(config.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("Network assign ip address space: %w", err)
+		}
 
-	addressSet, err := ipv4.IPv4AddressSpace(IPRange)
-	if err != nil {
-		return nil, fmt.Errorf("Network assign ip address space: %w.", err)
-	}
+		// Reserved addresses of the other family belong to the other config
+		var reserved []string
+		for _, ip := range reservedIP {
+			if addr, err := netip.ParseAddr(ip); err != nil || addr.Is6() == subnet.Addr().Is6() {
+				reserved = append(reserved, ip)
+			}
+		}
 
-	if len(containers) > len(addressSet) {
-		return nil, fmt.Errorf("Network assign ip address space of %d is too low to fit %d containers.", len(addressSet), len(containers))
-	}
+		allocator, err := ipalloc.NewAllocator(config.Subnet, config.IPRange, config.Gateway, reserved)
+		if err != nil {
+			return nil, fmt.Errorf("Network assign ip address space: %w", err)
+		}
 
-	delete(addressSet, gateway)
-	for _, ip := range reservedIP {
-		delete(addressSet, ip)
-	}
+		if available := allocator.Available(); len(containers) > available {
+			return nil, fmt.Errorf("Network assign ip address space of %d is too low to fit %d containers.", available, len(containers))
+		}
 
-	for _, container := range containers {
-		ip := addressSet.Pop()
-		container.Options.Connections[net.Name].IPv4 = &ip
+		for _, container := range containers {
+			addr, err := allocator.Next()
+			if err != nil {
+				return nil, fmt.Errorf("Network assign ip: %w", err)
+			}
+			ip := addr.String()
+			if allocator.Is6() {
+				container.Options.Connections[net.Name].IPv6 = &ip
+			} else {
+				container.Options.Connections[net.Name].IPv4 = &ip
+			}
+		}
 	}
 
 	return containers, nil
 }
 
+type ExecOptions struct {
+	LogOutput bool
+	Stdout    io.Writer              // Optional copy of stdout
+	Stderr    io.Writer              // Optional copy of stderr, e.g. a per-client log file
+	Restart   *process.RestartPolicy // Uses process.DefaultRestartPolicy if nil
+	OnExit    func(process.Event)    // Optional, called every time the exec'd process exits
+}
+
 // User responsible for Process.Close()
 func (container *Container) Exec(commands []string, logOutput bool) (*process.Process, error) {
+	return container.ExecWithOptions(commands, &ExecOptions{LogOutput: logOutput})
+}
+
+// User responsible for Process.Close()
+func (container *Container) ExecWithOptions(commands []string, execOptions *ExecOptions) (*process.Process, error) {
+	if execOptions == nil {
+		execOptions = &ExecOptions{}
+	}
+
+	start := func(commands []string) (*process.Session, error) {
+		return container.execSession(commands, execOptions)
+	}
+
+	session, err := start(commands)
+	if err != nil {
+		return nil, err
+	}
+
+	processOptions := process.Options{Restart: process.DefaultRestartPolicy(), OnExit: execOptions.OnExit}
+	if execOptions.Restart != nil {
+		processOptions.Restart = *execOptions.Restart
+	}
+
+	return process.NewProcess(session, commands, start, processOptions), nil
+}
+
+func (container *Container) execSession(commands []string, execOptions *ExecOptions) (*process.Session, error) {
 	options := dockerContainer.ExecOptions{
 		Cmd:          commands,
 		AttachStdout: true,
@@ -325,18 +422,31 @@ func (container *Container) Exec(commands []string, logOutput bool) (*process.Pr
 		return nil, fmt.Errorf("Container Exec failed to attach: %w.", err)
 	}
 	res.Conn.SetDeadline(time.Time{})
-	var buffer bytes.Buffer
 
-	tee := io.TeeReader(res.Reader, &buffer)
+	output := process.Output{Stdout: execOptions.Stdout, Stderr: execOptions.Stderr}
+	if execOptions.LogOutput {
+		reader, pipe := io.Pipe()
+		go logger.LogContainerExec(reader, commands, container.Name)
+		writer := newAsyncWriter(pipe)
 
-	if logOutput {
-		go logger.LogContainerExec(tee, commands, container.Name)
-	} else {
-		go func() {
-			io.Copy(io.Discard, tee)
-		}()
+		output.Stdout = writer
+		output.Stderr = writer
+		if execOptions.Stdout != nil {
+			output.Stdout = io.MultiWriter(execOptions.Stdout, writer)
+		}
+		if execOptions.Stderr != nil {
+			output.Stderr = io.MultiWriter(execOptions.Stderr, writer)
+		}
+		output.OnClose = func(err error) { writer.CloseWithError(err) }
+	}
+
+	inspect := func() (process.Status, error) {
+		inspectRes, err := container.Client.Cli.ContainerExecInspect(container.Client.Ctx, execRes.ID)
+		if err != nil {
+			return process.Status{}, fmt.Errorf("Container Exec failed to inspect: %w.", err)
+		}
+		return process.Status{Running: inspectRes.Running, ExitCode: inspectRes.ExitCode, Pid: inspectRes.Pid}, nil
 	}
 
-	execFunc := container.Exec
-	return process.NewProcess(res.Conn, &buffer, commands, execFunc), nil
+	return process.NewSession(res.Conn, res.Reader, inspect, output), nil
 }
@@ -0,0 +1,28 @@
+package container
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+)
+
+// Contents of a regular file in the container, which does not need to be running
+func (container *Container) ReadFile(path string) ([]byte, error) {
+	reader, _, err := container.Client.Cli.CopyFromContainer(container.Client.Ctx, container.ID, path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to copy %v from container %v: %w.", path, container.Name, err)
+	}
+	defer reader.Close()
+
+	archive := tar.NewReader(reader)
+	header, err := archive.Next()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %v from container %v: %w.", path, container.Name, err)
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return nil, fmt.Errorf("%v in container %v is not a regular file.", path, container.Name)
+	}
+
+	return io.ReadAll(archive)
+}
@@ -0,0 +1,113 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	dockerContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+
+	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/network"
+)
+
+const lifecyclePoolSize = 50
+
+// Timeout in seconds before the container is killed, nil uses the container default
+func (container *Container) Stop(timeout *int) error {
+	if err := container.Client.Cli.ContainerStop(
+		container.Client.Ctx,
+		container.ID,
+		dockerContainer.StopOptions{Timeout: timeout},
+	); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("Container %s stop failed: %w.", container.Name, err)
+	}
+	return nil
+}
+
+// Force removes a running container
+func (container *Container) Remove(force bool) error {
+	if err := container.Client.Cli.ContainerRemove(
+		container.Client.Ctx,
+		container.ID,
+		dockerContainer.RemoveOptions{Force: force},
+	); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("Container %s remove failed: %w.", container.Name, err)
+	}
+	return nil
+}
+
+func StopContainers(containers []*Container, timeout *int) error {
+	return forEachContainer(containers, func(container *Container) error {
+		return container.Stop(timeout)
+	})
+}
+
+func RemoveContainers(containers []*Container, force bool) error {
+	return forEachContainer(containers, func(container *Container) error {
+		return container.Remove(force)
+	})
+}
+
+// Stops and removes the containers and networks a simulation created. Reused objects are left untouched.
+func Teardown(client *client.Client, containers []*Container, networks []*network.Network) error {
+	var createdContainers []*Container
+	for _, container := range containers {
+		if container != nil && container.Created {
+			createdContainers = append(createdContainers, container)
+		}
+	}
+
+	var createdNetworks []*network.Network
+	for _, network := range networks {
+		if network != nil && network.Created {
+			createdNetworks = append(createdNetworks, network)
+		}
+	}
+
+	return RemoveAll(client, createdContainers, createdNetworks)
+}
+
+// Stops and removes the containers, then the networks, whoever created them. Used for listed runs.
+func RemoveAll(client *client.Client, containers []*Container, networks []*network.Network) error {
+	noWait := 0
+	errs := []error{
+		StopContainers(containers, &noWait),
+		RemoveContainers(containers, true),
+	}
+
+	for _, network := range networks {
+		if err := network.Remove(false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func forEachContainer(containers []*Container, fn func(*Container) error) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	sem := make(chan struct{}, lifecyclePoolSize)
+	for _, container := range containers {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(container *Container) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(container); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(container)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
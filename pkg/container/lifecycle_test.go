@@ -0,0 +1,191 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	dockerNetwork "github.com/docker/docker/api/types/network"
+	dockerClient "github.com/docker/docker/client"
+
+	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/network"
+)
+
+// Serves Docker API requests from a handler instead of a daemon
+type handlerTransport struct{ handler http.Handler }
+
+func (transport handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	transport.handler.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
+
+// Docker API where containers and networks named "missing-*" do not exist, "broken-*" fail
+// and "busy-*" networks have a connected container. Records every request.
+type fakeDocker struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (fake *fakeDocker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path[strings.Index(req.URL.Path[1:], "/")+1:]
+	fake.mu.Lock()
+	fake.requests = append(fake.requests, req.Method+" "+path)
+	fake.mu.Unlock()
+
+	name := path[strings.LastIndex(path, "/")+1:]
+	if strings.HasSuffix(path, "/stop") {
+		name = strings.Split(path, "/")[2]
+	}
+
+	switch {
+	case strings.HasPrefix(strings.TrimPrefix(name, "id-"), "missing-"):
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "No such object: " + name})
+	case strings.HasPrefix(name, "broken-"):
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "daemon error"})
+	case req.Method == http.MethodPost && path == "/networks/create":
+		var create dockerNetwork.CreateRequest
+		json.NewDecoder(req.Body).Decode(&create)
+		writeJSON(w, http.StatusCreated, dockerNetwork.CreateResponse{ID: "id-" + create.Name})
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "/networks/"):
+		fake.inspectNetwork(w, name)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Networks are looked up by name before they are created, by ID after
+func (fake *fakeDocker) inspectNetwork(w http.ResponseWriter, name string) {
+	inspect := dockerNetwork.Inspect{Name: strings.TrimPrefix(name, "id-"), ID: name}
+	switch {
+	case strings.HasPrefix(name, "reused"):
+		inspect.ID = "id-" + name
+	case !strings.HasPrefix(name, "id-"):
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "network " + name + " not found"})
+		return
+	case strings.HasPrefix(name, "id-busy-"):
+		inspect.Containers = map[string]dockerNetwork.EndpointResource{"client-1": {}}
+	}
+	writeJSON(w, http.StatusOK, inspect)
+}
+
+func (fake *fakeDocker) sent(request string) bool {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return slices.Contains(fake.requests, request)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func newFakeClient(t *testing.T) (*client.Client, *fakeDocker) {
+	t.Helper()
+
+	fake := &fakeDocker{}
+	cli, err := dockerClient.NewClientWithOpts(
+		dockerClient.WithHost("tcp://docker.test:2375"),
+		dockerClient.WithHTTPClient(&http.Client{Transport: handlerTransport{fake}}),
+		dockerClient.WithVersion("1.47"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client.Client{Ctx: context.Background(), Cli: cli}, fake
+}
+
+func fakeContainers(client *client.Client, names ...string) []*Container {
+	var containers []*Container
+	for _, name := range names {
+		containers = append(containers, &Container{Client: client, Name: name, ID: name, Created: true})
+	}
+	return containers
+}
+
+func TestStopAndRemoveContainers(t *testing.T) {
+	client, fake := newFakeClient(t)
+	containers := fakeContainers(client, "server", "missing-client")
+
+	timeout := 0
+	if err := StopContainers(containers, &timeout); err != nil {
+		t.Errorf("StopContainers: %v", err)
+	}
+	if err := RemoveContainers(containers, true); err != nil {
+		t.Errorf("RemoveContainers: %v", err)
+	}
+	for _, request := range []string{"POST /containers/server/stop", "DELETE /containers/server", "DELETE /containers/missing-client"} {
+		if !fake.sent(request) {
+			t.Errorf("Expected %v", request)
+		}
+	}
+
+	err := RemoveContainers(fakeContainers(client, "broken-db", "cache"), true)
+	if err == nil || !strings.Contains(err.Error(), "broken-db") || strings.Contains(err.Error(), "cache") {
+		t.Errorf("Expected only broken-db to fail, got %v", err)
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	client, fake := newFakeClient(t)
+
+	containers := fakeContainers(client, "server", "missing-client")
+	reusedContainer := &Container{Client: client, Name: "db", ID: "db"}
+	containers = append(containers, reusedContainer, nil)
+
+	var networks []*network.Network
+	for _, name := range []string{"free-net", "busy-net", "missing-net", "reused-net"} {
+		net, err := network.NewNetwork(client, name, network.Options{})
+		if err != nil {
+			t.Fatalf("NewNetwork %v: %v", name, err)
+		}
+		networks = append(networks, net)
+	}
+	networks = append(networks, nil)
+
+	err := Teardown(client, containers, networks)
+	if !errors.Is(err, network.ErrInUse) || strings.Count(err.Error(), "\n") != 0 {
+		t.Errorf("Expected only the busy network to fail, got %v", err)
+	}
+
+	for _, request := range []string{"DELETE /containers/server", "DELETE /containers/missing-client", "DELETE /networks/id-free-net"} {
+		if !fake.sent(request) {
+			t.Errorf("Expected %v", request)
+		}
+	}
+	for _, request := range []string{"POST /containers/db/stop", "DELETE /containers/db", "DELETE /networks/id-busy-net", "DELETE /networks/id-missing-net", "DELETE /networks/id-reused-net"} {
+		if fake.sent(request) {
+			t.Errorf("Unexpected %v", request)
+		}
+	}
+}
+
+func TestRemoveAllRemovesListedObjects(t *testing.T) {
+	client, fake := newFakeClient(t)
+
+	listed := &Container{Client: client, Name: "db", ID: "db"}
+	reused, err := network.NewNetwork(client, "reused-net", network.Options{Labels: map[string]string{"requested": "label"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused.Created || reused.Options.Labels["requested"] != "" {
+		t.Errorf("Reused network should keep its own labels and not count as created: %+v", reused)
+	}
+
+	if err := RemoveAll(client, []*Container{listed}, []*network.Network{reused}); err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range []string{"POST /containers/db/stop", "DELETE /containers/db", "DELETE /networks/id-reused-net"} {
+		if !fake.sent(request) {
+			t.Errorf("Expected %v", request)
+		}
+	}
+}
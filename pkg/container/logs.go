@@ -0,0 +1,119 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	dockerContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"deniable-im/im-sim/pkg/process"
+)
+
+// Copies the container's stdout and stderr so far, with timestamps, to w
+func (container *Container) WriteLogs(w io.Writer) error {
+	reader, err := container.Client.Cli.ContainerLogs(container.Client.Ctx, container.ID, dockerContainer.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to read logs of container %v: %w.", container.Name, err)
+	}
+	defer reader.Close()
+
+	if _, err := stdcopy.StdCopy(w, w, reader); err != nil {
+		return fmt.Errorf("Failed to copy logs of container %v: %w.", container.Name, err)
+	}
+	return nil
+}
+
+// Runs commands once and copies their stdout to w, failing on a non-zero exit code
+func (container *Container) ExecTo(commands []string, w io.Writer) error {
+	proc, err := container.ExecWithOptions(commands, &ExecOptions{
+		Stdout:  w,
+		Restart: &process.RestartPolicy{Mode: process.RestartNever},
+	})
+	if err != nil {
+		return err
+	}
+	defer proc.Close()
+
+	status, err := proc.Wait()
+	if err != nil {
+		return err
+	}
+	if status.ExitCode != 0 {
+		return fmt.Errorf("%s in container %v exited with %d: %s", strings.Join(commands, " "), container.Name, status.ExitCode, strings.TrimSpace(strings.Join(proc.ReadStderr('\n'), "")))
+	}
+	return nil
+}
+
+// Bytes of exec output held for the terminal logger before output is dropped
+const execLogLimit = 1 << 20
+
+// Hands exec output to the terminal logger without blocking the exec stream.
+// Output beyond execLogLimit that the logger has not caught up with is dropped.
+type asyncWriter struct {
+	mu      sync.Mutex
+	pending []byte
+	dropped int
+	closed  bool
+	err     error
+	notify  chan struct{}
+	dst     *io.PipeWriter
+}
+
+func newAsyncWriter(dst *io.PipeWriter) *asyncWriter {
+	w := &asyncWriter{notify: make(chan struct{}, 1), dst: dst}
+	go w.run()
+	return w
+}
+
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	if len(w.pending)+len(p) > execLogLimit {
+		w.dropped += len(p)
+	} else {
+		w.pending = append(w.pending, p...)
+	}
+	w.mu.Unlock()
+
+	w.wake()
+	return len(p), nil
+}
+
+// Flushes what is pending, then closes the pipe with err
+func (w *asyncWriter) CloseWithError(err error) {
+	w.mu.Lock()
+	w.closed, w.err = true, err
+	w.mu.Unlock()
+
+	w.wake()
+}
+
+func (w *asyncWriter) wake() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *asyncWriter) run() {
+	for range w.notify {
+		w.mu.Lock()
+		pending, dropped, closed, err := w.pending, w.dropped, w.closed, w.err
+		w.pending, w.dropped = nil, 0
+		w.mu.Unlock()
+
+		if dropped > 0 {
+			pending = append(pending, fmt.Sprintf("[%d bytes of output dropped]\n", dropped)...)
+		}
+		if _, werr := w.dst.Write(pending); werr != nil || closed {
+			w.dst.CloseWithError(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package container
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsyncWriterDoesNotBlock(t *testing.T) {
+	reader, pipe := io.Pipe()
+	w := newAsyncWriter(pipe)
+
+	// Nothing reads the pipe yet, writes beyond the limit are dropped instead of blocking
+	wrote := make(chan struct{})
+	go func() {
+		chunk := []byte(strings.Repeat("x", 1023) + "\n")
+		for range 2 * execLogLimit / len(chunk) {
+			w.Write(chunk)
+		}
+		close(wrote)
+	}()
+
+	select {
+	case <-wrote:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked on a stalled logger")
+	}
+
+	errClosed := errors.New("stream closed")
+	w.CloseWithError(errClosed)
+
+	logged, err := io.ReadAll(reader)
+	if !errors.Is(err, errClosed) {
+		t.Errorf("Expected the close error, got %v", err)
+	}
+	if len(logged) > 2*execLogLimit || !strings.Contains(string(logged), "bytes of output dropped") {
+		t.Errorf("Expected dropped output to be reported, got %d bytes", len(logged))
+	}
+}
@@ -8,11 +8,26 @@ import (
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"deniable-im/im-sim/internal/logger"
+	"deniable-im/im-sim/pkg/labels"
 	"deniable-im/im-sim/pkg/network"
 )
 
+// Limits applied to HostConfig.Resources. Zero fields are left unlimited.
+type Resources struct {
+	CPUShares  int64 // Relative weight against other containers, default 1024
+	CPUPeriod  int64 // CFS period in microseconds
+	CPUQuota   int64 // CFS quota in microseconds per CPUPeriod
+	NanoCPUs   int64 // CPU quota in units of 1e-9 CPUs
+	Memory     int64 // Bytes
+	MemorySwap int64 // Bytes of memory plus swap, -1 for unlimited swap
+	PidsLimit  int64
+}
+
 type Options struct {
 	Connections     map[string]*network.Connection
+	Resources       *Resources
+	Labels          labels.Labels
+	Env             []string // Appended to ContainerConfig.Env, e.g. "KEY=value"
 	ContainerConfig *dockerContainer.Config
 	HostConfig      *dockerContainer.HostConfig
 	NetworkConfig   *dockerNetwork.NetworkingConfig
@@ -34,12 +49,21 @@ func (options *Options) SetConfigs(image string, name string) {
 		options.ContainerConfig = &dockerContainer.Config{}
 		options.ContainerConfig.Image = image
 	} else {
-		logger.LogContainerOptions(fmt.Sprintf("[+] ContainerConfig explicit set to overwrite image in container: %s", image, name))
+		logger.LogContainerOptions(fmt.Sprintf("[+] ContainerConfig explicit set to overwrite image %s in container: %s", image, name))
+	}
+
+	if len(options.Env) > 0 {
+		options.ContainerConfig.Env = append(append([]string{}, options.ContainerConfig.Env...), options.Env...)
+	}
+
+	if options.Labels != nil {
+		options.ContainerConfig.Labels = labels.Merge(options.ContainerConfig.Labels, options.Labels)
 	}
 
 	if options.HostConfig == nil {
 		options.HostConfig = &dockerContainer.HostConfig{}
 	}
+	options.Resources.apply(&options.HostConfig.Resources)
 
 	if options.NetworkConfig == nil {
 		options.NetworkConfig = &dockerNetwork.NetworkingConfig{}
@@ -48,10 +72,13 @@ func (options *Options) SetConfigs(image string, name string) {
 		for networkName, conn := range options.Connections {
 			endPointSettings := &dockerNetwork.EndpointSettings{}
 			endPointSettings.IPAMConfig = &dockerNetwork.EndpointIPAMConfig{}
-			// Set custom IPv4 address
+			// Set custom IPv4 and IPv6 addresses
 			if conn.IPv4 != nil {
 				endPointSettings.IPAMConfig.IPv4Address = *conn.IPv4
 			}
+			if conn.IPv6 != nil {
+				endPointSettings.IPAMConfig.IPv6Address = *conn.IPv6
+			}
 
 			options.NetworkConfig.EndpointsConfig[networkName] = endPointSettings
 		}
@@ -73,6 +100,12 @@ func (options *Options) DeepCopy() *Options {
 		newOptions.Connections[name] = &newConn
 	}
 
+	newOptions.Labels = options.Labels.Copy()
+	newOptions.Env = append([]string(nil), options.Env...)
+	if options.Resources != nil {
+		newOptions.Resources = &Resources{}
+		*newOptions.Resources = *options.Resources
+	}
 	if options.ContainerConfig != nil {
 		newOptions.ContainerConfig = &dockerContainer.Config{}
 		*newOptions.ContainerConfig = *options.ContainerConfig
@@ -99,3 +132,32 @@ func (options *Options) DeepCopy() *Options {
 
 	return newOptions
 }
+
+func (resources *Resources) apply(hostResources *dockerContainer.Resources) {
+	if resources == nil {
+		return
+	}
+
+	if resources.CPUShares != 0 {
+		hostResources.CPUShares = resources.CPUShares
+	}
+	if resources.CPUPeriod != 0 {
+		hostResources.CPUPeriod = resources.CPUPeriod
+	}
+	if resources.CPUQuota != 0 {
+		hostResources.CPUQuota = resources.CPUQuota
+	}
+	if resources.NanoCPUs != 0 {
+		hostResources.NanoCPUs = resources.NanoCPUs
+	}
+	if resources.Memory != 0 {
+		hostResources.Memory = resources.Memory
+	}
+	if resources.MemorySwap != 0 {
+		hostResources.MemorySwap = resources.MemorySwap
+	}
+	if resources.PidsLimit != 0 {
+		pidsLimit := resources.PidsLimit
+		hostResources.PidsLimit = &pidsLimit
+	}
+}
@@ -0,0 +1,152 @@
+package container
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"deniable-im/im-sim/pkg/process"
+)
+
+const probeInterval = 250 * time.Millisecond
+
+var ErrNotReady = errors.New("Not ready")
+
+// Succeeds once the probed service is ready. Called repeatedly until then.
+type Probe func(ctx context.Context) error
+
+// Polls the probe until it succeeds or the timeout expires
+func WaitFor(ctx context.Context, name string, probe Probe, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		err := probe(ctx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s after %v: %w", ErrNotReady, name, timeout, err)
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+// Accepts TCP connections on addr
+func TCPProbe(addr string) Probe {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// Completes a TLS handshake on addr. A nil config skips certificate verification.
+func TLSProbe(addr string, config *tls.Config) Probe {
+	if config == nil {
+		config = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return func(ctx context.Context) error {
+		dialer := tls.Dialer{Config: config}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// Verifies a server against the CA at caPath under serverName, for TLSProbe
+func LoadTLSConfig(caPath string, serverName string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read CA: %w.", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates in %s.", caPath)
+	}
+
+	return &tls.Config{RootCAs: roots, ServerName: serverName}, nil
+}
+
+// Runs commands in the container, ready on exit code 0 and output containing expect
+func (container *Container) ExecProbe(commands []string, expect string) Probe {
+	return func(ctx context.Context) error {
+		proc, err := container.ExecWithOptions(commands, &ExecOptions{
+			Restart: &process.RestartPolicy{Mode: process.RestartNever},
+		})
+		if err != nil {
+			return err
+		}
+		defer proc.Close()
+
+		select {
+		case <-proc.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		status, err := proc.Wait()
+		if err != nil {
+			return err
+		}
+		if status.ExitCode != 0 {
+			return fmt.Errorf("%s exited with %d", strings.Join(commands, " "), status.ExitCode)
+		}
+
+		if output := strings.Join(proc.Read(), "\n"); !strings.Contains(output, expect) {
+			return fmt.Errorf("%s printed %q", strings.Join(commands, " "), output)
+		}
+		return nil
+	}
+}
+
+// Postgres accepts TCP connections. The socket alone is up during init, before the final restart.
+func (container *Container) PostgresProbe() Probe {
+	return container.ExecProbe([]string{"pg_isready", "-q", "-h", "127.0.0.1"}, "")
+}
+
+func (container *Container) RedisProbe() Probe {
+	return container.ExecProbe([]string{"redis-cli", "ping"}, "PONG")
+}
+
+// Address of the container on a network, reachable from the host for bridge networks
+func (container *Container) IPAddress(networkName string) (string, error) {
+	inspect, err := container.Client.Cli.ContainerInspect(container.Client.Ctx, container.ID)
+	if err != nil {
+		return "", fmt.Errorf("Container %s inspect failed: %w.", container.Name, err)
+	}
+
+	endpoint, ok := inspect.NetworkSettings.Networks[networkName]
+	if !ok || endpoint.IPAddress == "" {
+		return "", fmt.Errorf("Container %s has no address on %s.", container.Name, networkName)
+	}
+	return endpoint.IPAddress, nil
+}
+
+// Waits for the first line of a process.Lines stream, e.g. a client confirming its registration
+func WaitForLine(lines <-chan process.Line, timeout time.Duration) (process.Line, error) {
+	select {
+	case line, ok := <-lines:
+		if !ok {
+			return process.Line{}, fmt.Errorf("%w: process exited before the first line.", ErrNotReady)
+		}
+		return line, nil
+	case <-time.After(timeout):
+		return process.Line{}, fmt.Errorf("%w: no output after %v.", ErrNotReady, timeout)
+	}
+}
@@ -0,0 +1,27 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWaitForTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+
+	if err := WaitFor(context.Background(), "listener", TCPProbe(addr), time.Second); err != nil {
+		t.Errorf("Expected listener to be ready: %v", err)
+	}
+
+	listener.Close()
+	err = WaitFor(context.Background(), "closed listener", TCPProbe(addr), 600*time.Millisecond)
+	if !errors.Is(err, ErrNotReady) {
+		t.Errorf("Expected ErrNotReady, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	dockerContainer "github.com/docker/docker/api/types/container"
+)
+
+// Cumulative counters of a container at one point in time
+type Stats struct {
+	Read        time.Time
+	CPUTotal    uint64
+	SystemCPU   uint64
+	OnlineCPUs  uint32
+	Throttled   uint64 // Nanoseconds the container was throttled by its CPU quota
+	MemoryUsage uint64
+	MemoryLimit uint64
+	Pids        uint64
+	NetRx       uint64
+	NetTx       uint64
+	BlockRead   uint64
+	BlockWrite  uint64
+}
+
+func (container *Container) Stats() (*Stats, error) {
+	res, err := container.Client.Cli.ContainerStatsOneShot(container.Client.Ctx, container.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Container stats failed: %w.", err)
+	}
+	defer res.Body.Close()
+
+	return parseStats(res.Body)
+}
+
+// Decodes a Docker stats response into cumulative counters
+func parseStats(r io.Reader) (*Stats, error) {
+	var statsRes dockerContainer.StatsResponse
+	if err := json.NewDecoder(r).Decode(&statsRes); err != nil {
+		return nil, fmt.Errorf("Container stats failed to decode: %w.", err)
+	}
+
+	stats := &Stats{
+		Read:        statsRes.Read,
+		CPUTotal:    statsRes.CPUStats.CPUUsage.TotalUsage,
+		SystemCPU:   statsRes.CPUStats.SystemUsage,
+		OnlineCPUs:  statsRes.CPUStats.OnlineCPUs,
+		Throttled:   statsRes.CPUStats.ThrottlingData.ThrottledTime,
+		MemoryUsage: statsRes.MemoryStats.Usage,
+		MemoryLimit: statsRes.MemoryStats.Limit,
+		Pids:        statsRes.PidsStats.Current,
+	}
+
+	for _, network := range statsRes.Networks {
+		stats.NetRx += network.RxBytes
+		stats.NetTx += network.TxBytes
+	}
+
+	for _, entry := range statsRes.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlockRead += entry.Value
+		case "write":
+			stats.BlockWrite += entry.Value
+		}
+	}
+
+	return stats, nil
+}
+
+// CPU usage in percent of one CPU between two samples of the same container
+func (stats *Stats) CPUPercent(previous *Stats) float64 {
+	if previous == nil || stats.CPUTotal < previous.CPUTotal || stats.SystemCPU <= previous.SystemCPU {
+		return 0
+	}
+
+	cpuDelta := float64(stats.CPUTotal - previous.CPUTotal)
+	systemDelta := float64(stats.SystemCPU - previous.SystemCPU)
+	onlineCPUs := float64(stats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = 1
+	}
+
+	return cpuDelta / systemDelta * onlineCPUs * 100
+}
@@ -0,0 +1,68 @@
+package container
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const statsResponse = `{
+	"read": "2024-05-01T12:00:01Z",
+	"pids_stats": {"current": 7},
+	"cpu_stats": {
+		"cpu_usage": {"total_usage": 3000000000},
+		"system_cpu_usage": 20000000000,
+		"online_cpus": 4,
+		"throttling_data": {"throttled_time": 500}
+	},
+	"memory_stats": {"usage": 1048576, "limit": 8388608},
+	"blkio_stats": {"io_service_bytes_recursive": [
+		{"major": 8, "minor": 0, "op": "Read", "value": 4096},
+		{"major": 8, "minor": 0, "op": "Write", "value": 1024},
+		{"major": 8, "minor": 16, "op": "read", "value": 100},
+		{"major": 8, "minor": 0, "op": "Total", "value": 5120}
+	]},
+	"networks": {
+		"eth0": {"rx_bytes": 1000, "tx_bytes": 2000},
+		"eth1": {"rx_bytes": 10, "tx_bytes": 20}
+	}
+}`
+
+func TestParseStats(t *testing.T) {
+	stats, err := parseStats(strings.NewReader(statsResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Stats{
+		Read:        time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC),
+		CPUTotal:    3000000000,
+		SystemCPU:   20000000000,
+		OnlineCPUs:  4,
+		Throttled:   500,
+		MemoryUsage: 1048576,
+		MemoryLimit: 8388608,
+		Pids:        7,
+		NetRx:       1010,
+		NetTx:       2020,
+		BlockRead:   4196,
+		BlockWrite:  1024,
+	}
+	if !stats.Read.Equal(want.Read) {
+		t.Errorf("Read got %v, want %v", stats.Read, want.Read)
+	}
+	stats.Read = want.Read
+	if *stats != want {
+		t.Errorf("got %+v, want %+v", *stats, want)
+	}
+
+	previous := want
+	previous.CPUTotal -= 1000000000
+	previous.SystemCPU -= 10000000000
+	if percent := stats.CPUPercent(&previous); percent != 40 {
+		t.Errorf("CPUPercent got %v, want 40", percent)
+	}
+	if percent := stats.CPUPercent(nil); percent != 0 {
+		t.Errorf("CPUPercent without a previous sample got %v, want 0", percent)
+	}
+}
@@ -0,0 +1,334 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"deniable-im/im-sim/internal/logger"
+	"deniable-im/im-sim/pkg/client"
+)
+
+var (
+	ErrNotInBundle    = errors.New("Image not in bundle")
+	ErrBundleCorrupt  = errors.New("Bundle is corrupt")
+	ErrBundleMismatch = errors.New("Local image differs from bundle")
+)
+
+// Tagged image in a bundle. ID is the digest of its config, the image ID of the classic image store.
+// Manifest is the digest index.json lists for the ref, the image ID of the containerd image store.
+type BundleImage struct {
+	Ref      string
+	ID       string
+	Manifest string `json:",omitempty"`
+}
+
+// Whether a local image is this bundle image under either image store
+func (image BundleImage) matches(inspect types.ImageInspect) bool {
+	if inspect.ID == image.ID || (image.Manifest != "" && inspect.ID == image.Manifest) {
+		return true
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		if image.Manifest != "" && strings.HasSuffix(repoDigest, "@"+image.Manifest) {
+			return true
+		}
+	}
+	return false
+}
+
+// Annotation of the containerd image store naming the image of an index.json entry
+const containerdImageName = "io.containerd.image.name"
+
+// Entry of the manifest.json written by docker save
+type bundleManifest struct {
+	Config   string
+	RepoTags []string
+}
+
+// Saves the images to a single tar at path, readable by LoadBundle on another host.
+// The bundle is written next to path and only renamed into place once it verifies.
+func SaveBundle(client *client.Client, refs []string, path string) ([]BundleImage, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create bundle: %w.", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	res, err := client.Cli.ImageSave(client.Ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to save images: %w.", err)
+	}
+	defer res.Close()
+
+	if _, err := io.Copy(file, res); err != nil {
+		return nil, fmt.Errorf("Failed to write bundle: %w.", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to write bundle: %w.", err)
+	}
+
+	images, err := VerifyBundle(client, file.Name(), refs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return nil, fmt.Errorf("Failed to write bundle: %w.", err)
+	}
+	cacheBundle(path, images)
+	return images, nil
+}
+
+// Verifies and loads every image in the bundle
+func LoadBundle(client *client.Client, path string) ([]BundleImage, error) {
+	images, err := ReadBundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bundle: %w.", err)
+	}
+	defer file.Close()
+
+	res, err := client.Cli.ImageLoad(client.Ctx, file, false)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load bundle: %w.", err)
+	}
+	defer res.Body.Close()
+
+	if err := logger.LogImageLoad(res.Body); err != nil {
+		return nil, fmt.Errorf("Failed to load bundle %v: %w", path, err)
+	}
+
+	var refs []string
+	for _, image := range images {
+		refs = append(refs, image.Ref)
+	}
+	return VerifyBundle(client, path, refs)
+}
+
+// Checks that the bundle holds refs and that the local images have the same IDs
+func VerifyBundle(client *client.Client, path string, refs []string) ([]BundleImage, error) {
+	images, err := ReadBundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	byRef := make(map[string]BundleImage, len(images))
+	for _, image := range images {
+		byRef[image.Ref] = image
+	}
+
+	var errs []error
+	for _, ref := range refs {
+		image, ok := byRef[normalizeRef(ref)]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %v.", ErrNotInBundle, ref))
+			continue
+		}
+
+		inspect, _, err := client.Cli.ImageInspectWithRaw(client.Ctx, ref)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %v.", ErrMissingImage, ref))
+			continue
+		}
+		if !image.matches(inspect) {
+			errs = append(errs, fmt.Errorf("%w: %v is %v, bundle has %v.", ErrBundleMismatch, ref, inspect.ID, image.ID))
+		}
+	}
+
+	return images, errors.Join(errs...)
+}
+
+// Bundles already read, by path, size and modification time
+var (
+	bundleCache   = make(map[bundleKey][]BundleImage)
+	bundleCacheMu sync.Mutex
+)
+
+type bundleKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func newBundleKey(bundlePath string) (bundleKey, error) {
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return bundleKey{}, fmt.Errorf("Failed to open bundle: %w.", err)
+	}
+	absolute, err := filepath.Abs(bundlePath)
+	if err != nil {
+		return bundleKey{}, fmt.Errorf("Failed to open bundle: %w.", err)
+	}
+	return bundleKey{path: absolute, size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+func cacheBundle(bundlePath string, images []BundleImage) {
+	key, err := newBundleKey(bundlePath)
+	if err != nil {
+		return
+	}
+
+	bundleCacheMu.Lock()
+	defer bundleCacheMu.Unlock()
+	bundleCache[key] = slices.Clone(images)
+}
+
+// Lists the tagged images of a docker save tar and checks their config digests.
+// The result is cached until the file changes, so runs verifying the same bundle read it once.
+func ReadBundle(bundlePath string) ([]BundleImage, error) {
+	key, err := newBundleKey(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	bundleCacheMu.Lock()
+	defer bundleCacheMu.Unlock()
+
+	if images, ok := bundleCache[key]; ok {
+		return slices.Clone(images), nil
+	}
+
+	images, err := readBundle(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	bundleCache[key] = images
+	return slices.Clone(images), nil
+}
+
+func readBundle(bundlePath string) ([]BundleImage, error) {
+	var manifests []bundleManifest
+	var index v1.Index
+	if err := walkBundle(bundlePath, func(name string, content io.Reader) error {
+		switch name {
+		case "manifest.json":
+			return json.NewDecoder(content).Decode(&manifests)
+		case "index.json":
+			return json.NewDecoder(content).Decode(&index)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if manifests == nil {
+		return nil, fmt.Errorf("%w: %v has no manifest.json.", ErrBundleCorrupt, bundlePath)
+	}
+
+	configs := make(map[string]string)
+	for _, manifest := range manifests {
+		configs[path.Clean(manifest.Config)] = ""
+	}
+
+	if err := walkBundle(bundlePath, func(name string, content io.Reader) error {
+		if _, ok := configs[name]; !ok {
+			return nil
+		}
+		digest := sha256.New()
+		if _, err := io.Copy(digest, content); err != nil {
+			return err
+		}
+		configs[name] = "sha256:" + hex.EncodeToString(digest.Sum(nil))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Only written by the containerd image store and newer docker versions
+	indexed := make(map[string]string)
+	for _, descriptor := range index.Manifests {
+		if name := descriptor.Annotations[containerdImageName]; name != "" {
+			indexed[familiarRef(name)] = descriptor.Digest.String()
+		}
+	}
+
+	var images []BundleImage
+	for _, manifest := range manifests {
+		config := path.Clean(manifest.Config)
+		id := configs[config]
+		if id == "" {
+			return nil, fmt.Errorf("%w: config %v is missing.", ErrBundleCorrupt, config)
+		}
+		if expected := configDigest(config); expected != "" && expected != id {
+			return nil, fmt.Errorf("%w: config %v has digest %v.", ErrBundleCorrupt, config, id)
+		}
+
+		for _, tag := range manifest.RepoTags {
+			images = append(images, BundleImage{Ref: tag, ID: id, Manifest: indexed[familiarRef(tag)]})
+		}
+	}
+
+	return images, nil
+}
+
+func walkBundle(bundlePath string, visit func(name string, content io.Reader) error) error {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return fmt.Errorf("Failed to open bundle: %w.", err)
+	}
+	defer file.Close()
+
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v.", ErrBundleCorrupt, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := visit(path.Clean(header.Name), reader); err != nil {
+			return fmt.Errorf("Failed to read %v from bundle: %w.", header.Name, err)
+		}
+	}
+}
+
+// Digest named by the config path, "blobs/sha256/<hex>" or "<hex>.json"
+func configDigest(config string) string {
+	if hexDigest, ok := strings.CutPrefix(config, "blobs/sha256/"); ok {
+		return "sha256:" + hexDigest
+	}
+	if hexDigest, ok := strings.CutSuffix(config, ".json"); ok && !strings.Contains(hexDigest, "/") {
+		return "sha256:" + hexDigest
+	}
+	return ""
+}
+
+// Ref without the default registry and library prefixes that containerd names carry
+func familiarRef(ref string) string {
+	ref = normalizeRef(ref)
+	ref = strings.TrimPrefix(ref, "docker.io/")
+	return strings.TrimPrefix(ref, "library/")
+}
+
+// Adds the implicit latest tag the way docker save lists RepoTags
+func normalizeRef(ref string) string {
+	if strings.Contains(ref, "@") || strings.Contains(ref[strings.LastIndex(ref, "/")+1:], ":") {
+		return ref
+	}
+	return ref + ":latest"
+}
@@ -0,0 +1,111 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func writeBundle(t *testing.T, files map[string]string) string {
+	path := filepath.Join(t.TempDir(), "bundle.tar")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := tar.NewWriter(file)
+	for name, content := range files {
+		if err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadBundle(t *testing.T) {
+	config := `{"architecture":"amd64"}`
+	sum := sha256.Sum256([]byte(config))
+	digest := hex.EncodeToString(sum[:])
+
+	path := writeBundle(t, map[string]string{
+		"blobs/sha256/" + digest: config,
+		"manifest.json":          `[{"Config":"blobs/sha256/` + digest + `","RepoTags":["denim-client:latest"]}]`,
+	})
+
+	images, err := ReadBundle(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].Ref != normalizeRef("denim-client") || images[0].ID != "sha256:"+digest {
+		t.Errorf("Unexpected bundle images: %+v", images)
+	}
+
+	corrupt := writeBundle(t, map[string]string{
+		"blobs/sha256/" + digest: config + " ",
+		"manifest.json":          `[{"Config":"blobs/sha256/` + digest + `","RepoTags":["denim-client:latest"]}]`,
+	})
+
+	if _, err := ReadBundle(corrupt); !errors.Is(err, ErrBundleCorrupt) {
+		t.Errorf("Expected ErrBundleCorrupt, got %v", err)
+	}
+}
+
+func TestReadContainerdBundle(t *testing.T) {
+	config := `{"architecture":"arm64"}`
+	sum := sha256.Sum256([]byte(config))
+	digest := hex.EncodeToString(sum[:])
+	manifest := "sha256:" + strings.Repeat("ab", 32)
+
+	path := writeBundle(t, map[string]string{
+		"blobs/sha256/" + digest: config,
+		"manifest.json":          `[{"Config":"blobs/sha256/` + digest + `","RepoTags":["denim-client:latest"]}]`,
+		"index.json": `{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.index.v1+json","digest":"` + manifest +
+			`","size":100,"annotations":{"io.containerd.image.name":"docker.io/library/denim-client:latest"}}]}`,
+	})
+
+	images, err := ReadBundle(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].ID != "sha256:"+digest || images[0].Manifest != manifest {
+		t.Fatalf("Unexpected bundle images: %+v", images)
+	}
+
+	image := images[0]
+	for _, test := range []struct {
+		name    string
+		inspect types.ImageInspect
+		want    bool
+	}{
+		{"classic store", types.ImageInspect{ID: image.ID}, true},
+		{"containerd store", types.ImageInspect{ID: manifest}, true},
+		{"pulled", types.ImageInspect{ID: "sha256:other", RepoDigests: []string{"denim-client@" + manifest}}, true},
+		{"different image", types.ImageInspect{ID: "sha256:other"}, false},
+	} {
+		if got := image.matches(test.inspect); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// A changed file is read again
+	if err := os.WriteFile(path, []byte("not a tar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBundle(path); err == nil {
+		t.Error("Expected a changed bundle to be read again")
+	}
+}
@@ -0,0 +1,62 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Hash of the context contents, Dockerfile, build args and labels. Modification times are ignored.
+func contextHash(buildCtx string, source *Source, buildOpt types.ImageBuildOptions) (string, error) {
+	context, err := buildContext(buildCtx, source)
+	if err != nil {
+		return "", err
+	}
+	defer context.Close()
+
+	digest := sha256.New()
+	reader := tar.NewReader(context)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(digest, "%s\x00%c\x00%o\x00%s\x00", header.Name, header.Typeflag, header.Mode, header.Linkname)
+		if _, err := io.Copy(digest, reader); err != nil {
+			return "", err
+		}
+	}
+
+	fmt.Fprintf(digest, "dockerfile\x00%s\x00", buildOpt.Dockerfile)
+	writeSorted(digest, "arg", buildOpt.BuildArgs, func(value *string) string {
+		if value == nil {
+			return ""
+		}
+		return *value
+	})
+	writeSorted(digest, "label", buildOpt.Labels, func(value string) string { return value })
+
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+func writeSorted[V any](digest hash.Hash, kind string, values map[string]V, format func(V) string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(digest, "%s\x00%s\x00%s\x00", kind, key, format(values[key]))
+	}
+}
@@ -1,14 +1,17 @@
 package image
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	dockerImage "github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/errdefs"
 
 	"deniable-im/im-sim/internal/logger"
 	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/labels"
 )
 
 type PullOptions struct {
@@ -19,8 +22,13 @@ type PullOptions struct {
 type Options struct {
 	BuildOpt *types.ImageBuildOptions
 	PullOpt  *PullOptions
+	Labels   labels.Labels // Added to built images, pulled images keep their own
+	Source   *Source       // Protocol source for builds, Dockerfile defaults if nil
+	Rebuild  bool          // Build even if the tagged images were built from the same context
 }
 
+var ErrMissingImage = errors.New("Image not found locally")
+
 type Image struct {
 	client   *client.Client
 	buildCtx string
@@ -47,10 +55,46 @@ func NewImage(client *client.Client, buildCtx string, options *Options) (*Image,
 }
 
 func (image *Image) imageBuild(buildOpt types.ImageBuildOptions) error {
-	archive, err := archive.TarWithOptions(image.buildCtx, &archive.TarOptions{})
+	if image.Options.Labels != nil {
+		buildOpt.Labels = labels.Merge(buildOpt.Labels, image.Options.Labels)
+	}
+
+	buildArgs := make(map[string]*string)
+	if image.Options.Source != nil {
+		buildArgs = image.Options.Source.buildArgs()
+	}
+
+	// The build checks out the commit that was hashed, even if the branch moves meanwhile
+	rebuild := image.Options.Rebuild
+	commit, err := remoteCommit(image.buildCtx, buildOpt.Dockerfile, image.Options.Source)
+	if err != nil {
+		logger.LogImageCached(fmt.Sprintf("Rebuilding %s: %v", strings.Join(buildOpt.Tags, ", "), err))
+		rebuild = true
+	} else if commit != "" {
+		buildArgs[SourceRefArg] = &commit
+	}
+
+	for key, value := range buildOpt.BuildArgs {
+		buildArgs[key] = value
+	}
+	buildOpt.BuildArgs = buildArgs
+
+	hash, err := contextHash(image.buildCtx, image.Options.Source, buildOpt)
+	if err != nil {
+		return fmt.Errorf("Failed to hash build context: %w.", err)
+	}
+
+	if !rebuild && image.upToDate(buildOpt.Tags, hash) {
+		logger.LogImageCached(fmt.Sprintf("Unchanged: %s", strings.Join(buildOpt.Tags, ", ")))
+		return nil
+	}
+	buildOpt.Labels = labels.Merge(buildOpt.Labels, map[string]string{labels.ContextHash: hash})
+
+	archive, err := buildContext(image.buildCtx, image.Options.Source)
 	if err != nil {
 		return fmt.Errorf("Failed to get build context: %w.", err)
 	}
+	defer archive.Close()
 
 	res, err := image.client.Cli.ImageBuild(image.client.Ctx, archive, buildOpt)
 	if err != nil {
@@ -58,10 +102,43 @@ func (image *Image) imageBuild(buildOpt types.ImageBuildOptions) error {
 	}
 	defer res.Body.Close()
 
-	logger.LogImageBuild(res.Body)
+	if err := logger.LogImageBuild(res.Body); err != nil {
+		return fmt.Errorf("Failed to build %s from %s: %w", strings.Join(buildOpt.Tags, ", "), buildOpt.Dockerfile, err)
+	}
 	return nil
 }
 
+// Every tag exists and was built from a context with the same hash
+func (image *Image) upToDate(tags []string, hash string) bool {
+	if len(tags) == 0 {
+		return false
+	}
+
+	for _, tag := range tags {
+		inspect, _, err := image.client.Cli.ImageInspectWithRaw(image.client.Ctx, tag)
+		if err != nil || inspect.Config == nil || inspect.Config.Labels[labels.ContextHash] != hash {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks that every ref exists locally, for running without building
+func CheckImages(client *client.Client, refs []string) error {
+	var errs []error
+	for _, ref := range refs {
+		if _, _, err := client.Cli.ImageInspectWithRaw(client.Ctx, ref); err != nil {
+			if errdefs.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("%w: %v.", ErrMissingImage, ref))
+			} else {
+				errs = append(errs, fmt.Errorf("Failed to inspect image %v: %w.", ref, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (image *Image) imagePull(refStr string, pullOpt *dockerImage.PullOptions) error {
 	if pullOpt == nil {
 		pullOpt = &dockerImage.PullOptions{}
@@ -73,6 +150,8 @@ func (image *Image) imagePull(refStr string, pullOpt *dockerImage.PullOptions) e
 	}
 	defer res.Close()
 
-	logger.LogImagePull(res)
+	if err := logger.LogImagePull(res); err != nil {
+		return fmt.Errorf("Failed to pull %s: %w", refStr, err)
+	}
 	return nil
 }
@@ -0,0 +1,205 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+)
+
+// Build context directory the Dockerfiles copy a local checkout from
+const SourceDir = "source"
+
+// Build args understood by the Dockerfiles
+const (
+	SourceRepoArg   = "SOURCE_REPO"
+	SourceRefArg    = "SOURCE_REF"
+	SourceCommitArg = "SOURCE_COMMIT"
+)
+
+// Where the protocol source of a build comes from. Empty fields keep the Dockerfile defaults.
+type Source struct {
+	RepoURL  string `json:",omitempty"`
+	Ref      string `json:",omitempty"` // Branch, tag or commit to check out
+	LocalDir string `json:",omitempty"` // Checkout copied into the build context instead of cloning
+}
+
+func (source *Source) buildArgs() map[string]*string {
+	args := make(map[string]*string)
+	if source.RepoURL != "" {
+		args[SourceRepoArg] = &source.RepoURL
+	}
+	if source.Ref != "" {
+		args[SourceRefArg] = &source.Ref
+	}
+	if source.LocalDir != "" {
+		if commit := localCommit(source.LocalDir); commit != "" {
+			args[SourceCommitArg] = &commit
+		}
+	}
+	return args
+}
+
+// HEAD of the checkout with a -dirty suffix for uncommitted changes, empty if not a git repository
+func localCommit(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	commit := strings.TrimSpace(string(out))
+
+	status, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	if err == nil && len(strings.TrimSpace(string(status))) > 0 {
+		commit += "-dirty"
+	}
+	return commit
+}
+
+// Commit the Dockerfile clones, resolved with git ls-remote so a moved branch changes the context hash.
+// Empty if the build copies a local checkout or the Dockerfile has no default SOURCE_REPO.
+func remoteCommit(buildCtx, dockerfile string, source *Source) (string, error) {
+	var repo, ref string
+	if source != nil {
+		if source.LocalDir != "" {
+			return "", nil
+		}
+		repo, ref = source.RepoURL, source.Ref
+	}
+	if isCommit(ref) {
+		return ref, nil
+	}
+	if repo == "" {
+		if dockerfile == "" {
+			dockerfile = "Dockerfile"
+		}
+		repo = dockerfileArg(filepath.Join(buildCtx, dockerfile), SourceRepoArg)
+		if repo == "" {
+			return "", nil
+		}
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	out, err := exec.Command("git", "ls-remote", repo, ref, ref+"^{}").Output()
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve %v of %v: %w.", ref, repo, err)
+	}
+
+	// Annotated tags are listed twice, the peeled entry names the commit
+	commit := ""
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		hash, name, found := strings.Cut(line, "\t")
+		if !found {
+			continue
+		}
+		if commit == "" || strings.HasSuffix(name, "^{}") {
+			commit = hash
+		}
+	}
+	if commit == "" {
+		return "", fmt.Errorf("Failed to resolve %v of %v: no such ref.", ref, repo)
+	}
+	return commit, nil
+}
+
+func isCommit(ref string) bool {
+	return len(ref) == 40 && strings.Trim(ref, "0123456789abcdef") == ""
+}
+
+// Default value of an ARG in the Dockerfile, empty if it has none
+func dockerfileArg(dockerfile, name string) string {
+	data, err := os.ReadFile(dockerfile)
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "ARG") {
+			continue
+		}
+		if value, found := strings.CutPrefix(fields[1], name+"="); found {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// Tar of buildCtx, with the local checkout in place of SourceDir if the source has one
+func buildContext(buildCtx string, source *Source) (io.ReadCloser, error) {
+	context, err := archive.TarWithOptions(buildCtx, &archive.TarOptions{})
+	if err != nil || source == nil || source.LocalDir == "" {
+		return context, err
+	}
+
+	local, err := archive.TarWithOptions(source.LocalDir, &archive.TarOptions{
+		ExcludePatterns: []string{".git", "target"},
+	})
+	if err != nil {
+		context.Close()
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer context.Close()
+		defer local.Close()
+
+		archiveWriter := tar.NewWriter(writer)
+		err := copyTar(archiveWriter, context, func(name string) (string, bool) {
+			return name, name != SourceDir+"/" && !strings.HasPrefix(name, SourceDir+"/")
+		})
+		if err == nil {
+			err = copyTar(archiveWriter, local, func(name string) (string, bool) {
+				renamed := path.Join(SourceDir, name)
+				if strings.HasSuffix(name, "/") {
+					renamed += "/"
+				}
+				return renamed, true
+			})
+		}
+		if err == nil {
+			err = archiveWriter.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader, nil
+}
+
+// Copies the entries rename keeps, under their new names
+func copyTar(writer *tar.Writer, reader io.Reader, rename func(string) (string, bool)) error {
+	archiveReader := tar.NewReader(reader)
+	for {
+		header, err := archiveReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, keep := rename(header.Name)
+		if !keep {
+			continue
+		}
+		header.Name = name
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname, _ = rename(header.Linkname)
+		}
+
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(writer, archiveReader); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package image
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildContextWithLocalSource(t *testing.T) {
+	buildCtx := t.TempDir()
+	checkout := t.TempDir()
+
+	for path, content := range map[string]string{
+		filepath.Join(buildCtx, "Dockerfile.client"):        "FROM scratch",
+		filepath.Join(buildCtx, SourceDir, ".gitkeep"):      "",
+		filepath.Join(checkout, "Cargo.toml"):               "[workspace]",
+		filepath.Join(checkout, "client", "src", "main.rs"): "fn main() {}",
+		filepath.Join(checkout, "target", "client"):         "binary",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	context, err := buildContext(buildCtx, &Source{LocalDir: checkout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer context.Close()
+
+	files := make(map[string]bool)
+	reader := tar.NewReader(context)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = true
+	}
+
+	for _, expected := range []string{"Dockerfile.client", "source/Cargo.toml", "source/client/src/main.rs"} {
+		if !files[expected] {
+			t.Errorf("Missing %v in build context", expected)
+		}
+	}
+	for _, unexpected := range []string{"source/.gitkeep", "source/target/client"} {
+		if files[unexpected] {
+			t.Errorf("Unexpected %v in build context", unexpected)
+		}
+	}
+}
+
+func TestRemoteCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+	first := git("rev-parse", "HEAD")
+	git("tag", "-a", "v1", "-m", "v1")
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	head := git("rev-parse", "HEAD")
+
+	buildCtx := t.TempDir()
+	dockerfile := "FROM scratch\nARG " + SourceRepoArg + "=" + repo + "\nARG " + SourceRefArg + "\n"
+	if err := os.WriteFile(filepath.Join(buildCtx, "Dockerfile.client"), []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source *Source
+		want   string
+	}{
+		{"Dockerfile default repo", nil, head},
+		{"annotated tag", &Source{RepoURL: repo, Ref: "v1"}, first},
+		{"pinned commit", &Source{Ref: first}, first},
+		{"local checkout", &Source{LocalDir: repo}, ""},
+	}
+	for _, test := range tests {
+		commit, err := remoteCommit(buildCtx, "Dockerfile.client", test.source)
+		if err != nil || commit != test.want {
+			t.Errorf("%v: got %q, %v, want %q", test.name, commit, err, test.want)
+		}
+	}
+
+	if _, err := remoteCommit(buildCtx, "Dockerfile.client", &Source{Ref: "missing"}); err == nil {
+		t.Error("Expected an unknown ref to fail")
+	}
+	if commit, err := remoteCommit(t.TempDir(), "Dockerfile.client", nil); err != nil || commit != "" {
+		t.Errorf("Without a source repo got %q, %v", commit, err)
+	}
+}
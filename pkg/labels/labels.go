@@ -0,0 +1,99 @@
+package labels
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Docker label keys set on the objects the simulator creates. Containers and networks
+// belong to one run, images are shared between runs and carry no Run or User label.
+const (
+	Simulator = "im-sim.simulator"
+	Run       = "im-sim.run"
+	Role      = "im-sim.role"
+	User      = "im-sim.user"
+	Namespace = "im-sim.namespace"
+
+	ContextHash = "im-sim.context-hash" // Set on built images to skip unchanged rebuilds
+)
+
+// Value of the Simulator label
+const SimulatorName = "im-sim"
+
+const (
+	RoleClient    = "client"
+	RoleServer    = "server"
+	RoleDB        = "db"
+	RoleCache     = "cache"
+	RoleNetwork   = "network"
+	RoleImage     = "image"
+	RoleNamespace = "namespace" // Network claiming a namespace index
+)
+
+type Labels map[string]string
+
+func New(runID string, role string) Labels {
+	return Labels{
+		Simulator: SimulatorName,
+		Run:       runID,
+		Role:      role,
+	}
+}
+
+// Labels of a built image. Without a run ID, so unchanged images are reused by later runs.
+func Image(role string) Labels {
+	return Labels{
+		Simulator: SimulatorName,
+		Role:      role,
+	}
+}
+
+// Copy with the user label set
+func (labels Labels) WithUser(userID string) Labels {
+	newLabels := labels.Copy()
+	newLabels[User] = userID
+	return newLabels
+}
+
+func (labels Labels) Copy() Labels {
+	if labels == nil {
+		return nil
+	}
+
+	newLabels := make(Labels, len(labels))
+	for key, value := range labels {
+		newLabels[key] = value
+	}
+	return newLabels
+}
+
+// New map with the labels of every argument, later arguments win
+func Merge(labelMaps ...map[string]string) Labels {
+	merged := make(Labels)
+	for _, labelMap := range labelMaps {
+		for key, value := range labelMap {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// Unique per simulation run, sortable by start time
+func NewRunID() string {
+	bytes := make([]byte, 3)
+	rand.Read(bytes)
+	return fmt.Sprintf("%v-%v", time.Now().Format("20060102-150405"), hex.EncodeToString(bytes))
+}
+
+// Matches objects of one run, or of every run if runID is empty
+func Filter(runID string) filters.Args {
+	args := filters.NewArgs(filters.Arg("label", fmt.Sprintf("%v=%v", Simulator, SimulatorName)))
+	if runID != "" {
+		args.Add("label", fmt.Sprintf("%v=%v", Run, runID))
+	}
+	return args
+}
@@ -0,0 +1,44 @@
+package labels
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	all := Filter("")
+	if got := all.Get("label"); !slices.Equal(got, []string{"im-sim.simulator=im-sim"}) {
+		t.Errorf("Filter of every run got %v", got)
+	}
+
+	run := Filter("20240501-120000-abcdef")
+	got := run.Get("label")
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"im-sim.run=20240501-120000-abcdef", "im-sim.simulator=im-sim"}) {
+		t.Errorf("Filter of one run got %v", got)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	client := New("run-1", RoleClient).WithUser("7")
+	if client[Simulator] != SimulatorName || client[Run] != "run-1" || client[Role] != RoleClient || client[User] != "7" {
+		t.Errorf("Unexpected client labels %v", client)
+	}
+
+	// Images are shared between runs
+	image := Image(RoleServer)
+	if _, ok := image[Run]; ok || image[Role] != RoleServer || image[Simulator] != SimulatorName {
+		t.Errorf("Unexpected image labels %v", image)
+	}
+
+	merged := Merge(map[string]string{Role: RoleDB, "other": "kept"}, image)
+	if merged[Role] != RoleServer || merged["other"] != "kept" {
+		t.Errorf("Later labels should win, got %v", merged)
+	}
+
+	base := New("run-1", RoleClient)
+	base.WithUser("8")
+	if _, ok := base[User]; ok {
+		t.Error("WithUser modified the original labels")
+	}
+}
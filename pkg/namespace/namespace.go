@@ -0,0 +1,217 @@
+package namespace
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+
+	dockerNetwork "github.com/docker/docker/api/types/network"
+
+	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/labels"
+	"deniable-im/im-sim/pkg/network"
+)
+
+// Subnets are 10.<10+Index>.240.0/20, which bounds the number of concurrent runs
+const maxIndex = 245
+
+// Derives the names and addresses of one simulation run so runs can share a host.
+// Index 0 is the historical 10.10.240.0/20 subnet that .client.env points at.
+type Namespace struct {
+	RunID string
+	Index int
+	IPv6  bool             // Dual-stack client network with an fd00:10:0:<Index>::/64 subnet
+	Claim *network.Network // Reserves Index on the host until it is removed with the run's networks
+}
+
+// Picks the lowest index whose subnets no network of another run uses and claims it. The claim is a
+// network named after the index, so of two processes picking the same index only one can create it.
+func New(client *client.Client, runID string) (*Namespace, error) {
+	used, err := usedIndices(client, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	for index := 0; index <= maxIndex; index++ {
+		if used[index] {
+			continue
+		}
+
+		ns := &Namespace{RunID: runID, Index: index}
+		claim, err := network.NewNetwork(client, ns.claimName(), network.Options{
+			Profile:   network.ProfileInternal,
+			IPAM:      &dockerNetwork.IPAM{Config: []dockerNetwork.IPAMConfig{{Subnet: ns.claimSubnet()}}},
+			Labels:    ns.Labels(labels.RoleNamespace),
+			Exclusive: true,
+		})
+		if errors.Is(err, network.ErrExists) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Namespace failed to claim index %d: %w", index, err)
+		}
+
+		// A network without labels may have taken the subnets since they were listed
+		used, err = usedIndices(client, runID)
+		if err != nil || used[index] {
+			claim.Remove(false)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		ns.Claim = claim
+		return ns, nil
+	}
+
+	return nil, fmt.Errorf("Namespace found no free subnet, %d indices in use on host.", len(used))
+}
+
+// Indices labelled on networks of other runs or overlapping any subnet of a network on the host
+func usedIndices(client *client.Client, runID string) (map[int]bool, error) {
+	list, err := client.Cli.NetworkList(client.Ctx, dockerNetwork.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Namespace failed to list networks: %w.", err)
+	}
+	return indicesInUse(list, runID), nil
+}
+
+func indicesInUse(list []dockerNetwork.Summary, runID string) map[int]bool {
+	used := make(map[int]bool)
+	for _, summary := range list {
+		if summary.Labels[labels.Run] == runID {
+			continue
+		}
+		if index, err := strconv.Atoi(summary.Labels[labels.Namespace]); err == nil {
+			used[index] = true
+		}
+
+		for _, config := range summary.IPAM.Config {
+			subnet, err := netip.ParsePrefix(config.Subnet)
+			if err != nil {
+				continue
+			}
+			for index := 0; index <= maxIndex; index++ {
+				ns := Namespace{Index: index}
+				for _, own := range []string{ns.Subnet(), ns.SubnetIPv6(), ns.claimSubnet()} {
+					if netip.MustParsePrefix(own).Overlaps(subnet) {
+						used[index] = true
+					}
+				}
+			}
+		}
+	}
+	return used
+}
+
+// Removes the claim so Index can be picked again. Networks of the run still hold their subnets,
+// New skips indices whose subnets are in use.
+func (ns *Namespace) Release() error {
+	if ns.Claim == nil {
+		return nil
+	}
+	return ns.Claim.Remove(false)
+}
+
+func (ns *Namespace) claimName() string {
+	return fmt.Sprintf("im-sim-namespace-%d", ns.Index)
+}
+
+// Just below the client subnet, so the claim holds no addresses a run uses
+func (ns *Namespace) claimSubnet() string {
+	return fmt.Sprintf("10.%d.239.252/30", 10+ns.Index)
+}
+
+// Unique container or network name for this run
+func (ns *Namespace) Name(base string) string {
+	return fmt.Sprintf("%v-%v", base, ns.RunID)
+}
+
+// Run labels including the namespace index
+func (ns *Namespace) Labels(role string) labels.Labels {
+	runLabels := labels.New(ns.RunID, role)
+	runLabels[labels.Namespace] = strconv.Itoa(ns.Index)
+	return runLabels
+}
+
+func (ns *Namespace) Subnet() string {
+	return fmt.Sprintf("10.%d.240.0/20", 10+ns.Index)
+}
+
+func (ns *Namespace) IPRange() string {
+	return fmt.Sprintf("10.%d.248.0/21", 10+ns.Index)
+}
+
+func (ns *Namespace) Gateway() string {
+	return fmt.Sprintf("10.%d.248.1", 10+ns.Index)
+}
+
+func (ns *Namespace) ServerIP() string {
+	return fmt.Sprintf("10.%d.248.2", 10+ns.Index)
+}
+
+func (ns *Namespace) SubnetIPv6() string {
+	return fmt.Sprintf("fd00:10:0:%x::/64", ns.Index)
+}
+
+func (ns *Namespace) IPRangeIPv6() string {
+	return fmt.Sprintf("fd00:10:0:%x::/112", ns.Index)
+}
+
+func (ns *Namespace) GatewayIPv6() string {
+	return fmt.Sprintf("fd00:10:0:%x::1", ns.Index)
+}
+
+// Empty unless IPv6 is set
+func (ns *Namespace) ServerIPv6() string {
+	if !ns.IPv6 {
+		return ""
+	}
+	return fmt.Sprintf("fd00:10:0:%x::2", ns.Index)
+}
+
+// Server addresses to reserve and map, both families for dual-stack
+func (ns *Namespace) ServerIPs() []string {
+	if !ns.IPv6 {
+		return []string{ns.ServerIP()}
+	}
+	return []string{ns.ServerIP(), ns.ServerIPv6()}
+}
+
+func (ns *Namespace) IPAM() *dockerNetwork.IPAM {
+	ipam := &dockerNetwork.IPAM{
+		Config: []dockerNetwork.IPAMConfig{
+			{
+				Subnet:  ns.Subnet(),
+				IPRange: ns.IPRange(),
+				Gateway: ns.Gateway(),
+			},
+		},
+	}
+
+	if ns.IPv6 {
+		ipam.Config = append(ipam.Config, dockerNetwork.IPAMConfig{
+			Subnet:  ns.SubnetIPv6(),
+			IPRange: ns.IPRangeIPv6(),
+			Gateway: ns.GatewayIPv6(),
+		})
+	}
+
+	return ipam
+}
+
+// Environment pointing clients at this run's server. The server certificate is valid for the
+// "server" alias, so other namespaces and dual-stack runs use it instead of the IP baked into
+// .client.env. The alias resolves to both server addresses. Aliases are scoped to a network,
+// so every run's server can use the same one on its own client network.
+func (ns *Namespace) ClientEnv() []string {
+	if ns.Index == 0 && !ns.IPv6 {
+		return nil
+	}
+
+	return []string{
+		"HTTPS_SERVER_URL=https://server:443",
+		"HTTP_SERVER_URL=http://server:80",
+	}
+}
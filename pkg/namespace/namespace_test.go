@@ -0,0 +1,103 @@
+package namespace
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dockerNetwork "github.com/docker/docker/api/types/network"
+	dockerClient "github.com/docker/docker/client"
+
+	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/labels"
+)
+
+func summary(name string, networkLabels map[string]string, subnets ...string) dockerNetwork.Summary {
+	s := dockerNetwork.Summary{Name: name, Labels: networkLabels}
+	for _, subnet := range subnets {
+		s.IPAM.Config = append(s.IPAM.Config, dockerNetwork.IPAMConfig{Subnet: subnet})
+	}
+	return s
+}
+
+func TestIndicesInUse(t *testing.T) {
+	list := []dockerNetwork.Summary{
+		summary("bridge", nil, "172.17.0.0/16"),
+		summary("IMvlan-other", (&Namespace{RunID: "other", Index: 0}).Labels(labels.RoleNetwork), "10.10.240.0/20"),
+		summary("IMvlan-own", (&Namespace{RunID: "own", Index: 4}).Labels(labels.RoleNetwork), "10.14.240.0/20"),
+		summary("unlabelled", nil, "10.11.0.0/16"),
+		summary("unlabelled-v6", nil, "fd00:10:0:2::/64"),
+		summary("claim-other", (&Namespace{RunID: "other", Index: 3}).Labels(labels.RoleNamespace)),
+	}
+
+	used := indicesInUse(list, "own")
+	for index, want := range map[int]bool{0: true, 1: true, 2: true, 3: true, 4: false, 5: false} {
+		if used[index] != want {
+			t.Errorf("Index %d: used %v, want %v", index, used[index], want)
+		}
+	}
+	if len(used) != 4 {
+		t.Errorf("Unexpected indices in use: %v", used)
+	}
+}
+
+// Serves Docker API requests from a handler instead of a daemon
+type handlerTransport struct{ handler http.HandlerFunc }
+
+func (transport handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	transport.handler(recorder, req)
+	return recorder.Result(), nil
+}
+
+func TestNewSkipsClaimedIndex(t *testing.T) {
+	list := []dockerNetwork.Summary{summary("IMvlan-other", (&Namespace{RunID: "other", Index: 0}).Labels(labels.RoleNetwork), "10.10.240.0/20")}
+	var claims []string
+
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.Method == http.MethodGet && strings.HasSuffix(req.URL.Path, "/networks"):
+			json.NewEncoder(w).Encode(list)
+		case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/networks/create"):
+			var create dockerNetwork.CreateRequest
+			json.NewDecoder(req.Body).Decode(&create)
+			claims = append(claims, create.Name)
+
+			// Another process claimed index 1 after the networks were listed
+			if create.Name == "im-sim-namespace-1" {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"message": "network with name im-sim-namespace-1 already exists"})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(dockerNetwork.CreateResponse{ID: "id-" + create.Name})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "unexpected " + req.URL.Path})
+		}
+	}
+
+	cli, err := dockerClient.NewClientWithOpts(
+		dockerClient.WithHost("tcp://docker.test:2375"),
+		dockerClient.WithHTTPClient(&http.Client{Transport: handlerTransport{handler}}),
+		dockerClient.WithVersion("1.47"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := New(&client.Client{Ctx: context.Background(), Cli: cli}, "own")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Index != 2 || ns.Claim == nil || ns.Claim.Name != "im-sim-namespace-2" || !ns.Claim.Created {
+		t.Errorf("Expected a claim of index 2, got index %d and %+v", ns.Index, ns.Claim)
+	}
+	if strings.Join(claims, ",") != "im-sim-namespace-1,im-sim-namespace-2" {
+		t.Errorf("Unexpected claims: %v", claims)
+	}
+}
@@ -1,17 +1,28 @@
 package network
 
 import (
-	"deniable-im/im-sim/internal/types"
+	"net/netip"
 )
 
 type AddrMapping struct {
-	pair types.Pair[*Network, string]
+	network *Network
+	ipv4    *string
+	ipv6    *string
 }
 
-func NewAddrMapping(network *Network, ipv4 string) AddrMapping {
-	return AddrMapping{types.Pair[*Network, string]{Fst: network, Snd: ipv4}}
+// Fixed addresses on the network, at most one IPv4 and one IPv6 address for dual-stack
+func NewAddrMapping(network *Network, ips ...string) AddrMapping {
+	mapping := AddrMapping{network: network}
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() && !addr.Is4In6() {
+			mapping.ipv6 = &ip
+		} else {
+			mapping.ipv4 = &ip
+		}
+	}
+	return mapping
 }
 
 func (addrMapping AddrMapping) GetConnection() *Connection {
-	return &Connection{Network: addrMapping.pair.Fst, IPv4: &addrMapping.pair.Snd}
+	return &Connection{Network: addrMapping.network, IPv4: addrMapping.ipv4, IPv6: addrMapping.ipv6}
 }
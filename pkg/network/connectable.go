@@ -7,6 +7,7 @@ type Connectable interface {
 type Connection struct {
 	Network *Network
 	IPv4    *string
+	IPv6    *string
 }
 
 type Connections = map[string]*Connection
@@ -3,14 +3,34 @@ package network
 import (
 	"deniable-im/im-sim/internal/logger"
 	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/labels"
+	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+)
+
+var (
+	ErrConfigMismatch     = errors.New("Network configuration differs from the requested options")
+	ErrInUse              = errors.New("Network has connected containers")
+	ErrExists             = errors.New("Network already exists")
+	ErrNoCaptureInterface = errors.New("Network has no host interface carrying its traffic")
 )
 
 type Options struct {
-	Driver string
-	IPAM   *network.IPAM
+	Driver     string
+	Profile    Profile           // Sets Driver, DriverOpts and Internal if not empty
+	Parent     string            // Host interface for macvlan and ipvlan, ParentAuto for the default route, a dummy link if empty
+	MTU        int               // Driver default if 0
+	DriverOpts map[string]string // Merged over the profile's options
+	Internal   bool
+	IPAM       *network.IPAM // Dual-stack with one IPv4 and one IPv6 config
+	EnableIPv6 bool          // Required for IPv6 configs in IPAM
+	Labels     labels.Labels
+	Recreate   bool // Replace an existing network whose configuration differs instead of failing
+	Exclusive  bool // Fail with ErrExists instead of reusing an existing network, also when its subnet is taken
 }
 
 type Network struct {
@@ -18,27 +38,178 @@ type Network struct {
 	Name    string
 	ID      string
 	Options Options
+	Created bool // False if an existing network was reused
 }
 
-func NewNetwork(client *client.Client, name string, options Options) *Network {
-	inspectRes, err := client.Cli.NetworkInspect(client.Ctx, name, network.InspectOptions{})
-	if err != nil {
-		createRes, err := client.Cli.NetworkCreate(client.Ctx, name, network.CreateOptions{
-			Driver: options.Driver,
-			IPAM:   options.IPAM,
-		})
+// Creates the network, or reuses an existing one with the same name and configuration
+func NewNetwork(client *client.Client, name string, options Options) (*Network, error) {
+	if options.Profile != "" {
+		driver, driverOpts, internal, err := options.Profile.driverConfig(options.Parent, options.MTU)
 		if err != nil {
-			panic(err)
-		} else {
-			logger.LogNetworkNew(fmt.Sprintf("[+] Network %s created", inspectRes.Name))
-			return &Network{client: client, Name: name, ID: createRes.ID, Options: options}
+			return nil, err
+		}
+		for key, value := range options.DriverOpts {
+			driverOpts[key] = value
+		}
+		options.Driver, options.DriverOpts, options.Internal = driver, driverOpts, internal
+	}
+
+	if options.Exclusive {
+		return createNetwork(client, name, options)
+	}
+
+	inspectRes, err := client.Cli.NetworkInspect(client.Ctx, name, network.InspectOptions{})
+	if err != nil && !errdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("Failed to inspect network %s: %w.", name, err)
+	}
+
+	if err == nil {
+		existing := fromInspect(client, inspectRes)
+		drift := configDrift(options, inspectRes)
+		if drift == nil {
+			logger.LogNetworkNew(fmt.Sprintf("[+] Network %s already exists", name))
+			return existing, nil
+		}
+
+		if !options.Recreate {
+			return nil, fmt.Errorf("Network %s: %w: %w.", name, ErrConfigMismatch, drift)
+		}
+
+		logger.LogNetworkNew(fmt.Sprintf("[~] Network %s differs (%v), recreating", name, drift))
+		if err := existing.Remove(false); err != nil {
+			return nil, err
+		}
+	}
+
+	return createNetwork(client, name, options)
+}
+
+// Docker rejects duplicate names and overlapping subnets, which Exclusive reports as ErrExists
+func createNetwork(client *client.Client, name string, options Options) (*Network, error) {
+	createRes, err := client.Cli.NetworkCreate(client.Ctx, name, network.CreateOptions{
+		Driver:     options.Driver,
+		Options:    options.DriverOpts,
+		Internal:   options.Internal,
+		IPAM:       options.IPAM,
+		EnableIPv6: &options.EnableIPv6,
+		Labels:     options.Labels,
+	})
+	if options.Exclusive && (errdefs.IsConflict(err) || errdefs.IsForbidden(err)) {
+		return nil, fmt.Errorf("Network %s: %w: %w", name, ErrExists, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to create network %s: %w.", name, err)
+	}
+
+	logger.LogNetworkNew(fmt.Sprintf("[+] Network %s created", name))
+	return &Network{client: client, Name: name, ID: createRes.ID, Options: options, Created: true}, nil
+}
+
+// Removes the network. Connected containers are disconnected first if force is set,
+// otherwise ErrInUse is returned. A network that no longer exists is not an error.
+func (net *Network) Remove(force bool) error {
+	inspectRes, err := net.client.Cli.NetworkInspect(net.client.Ctx, net.ID, network.InspectOptions{})
+	if errdefs.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to inspect network %s: %w.", net.Name, err)
+	}
+
+	if len(inspectRes.Containers) > 0 {
+		if !force {
+			return fmt.Errorf("Network %s: %w: %d containers.", net.Name, ErrInUse, len(inspectRes.Containers))
+		}
+		for containerID := range inspectRes.Containers {
+			if err := net.Disconnect(containerID, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := net.client.Cli.NetworkRemove(net.client.Ctx, net.ID); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("Network %s remove failed: %w.", net.Name, err)
+	}
+
+	logger.LogNetworkNew(fmt.Sprintf("[-] Network %s removed", net.Name))
+	return nil
+}
+
+// Disconnects a container by ID or name, force also removes the endpoint of a stopped container
+func (net *Network) Disconnect(container string, force bool) error {
+	if err := net.client.Cli.NetworkDisconnect(net.client.Ctx, net.ID, container, force); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("Failed to disconnect %s from network %s: %w.", container, net.Name, err)
+	}
+	return nil
+}
+
+// Differences between the requested options and an existing network, nil if it can be reused.
+// Driver options the request leaves unset are ignored since Docker fills in defaults.
+func configDrift(options Options, existing network.Inspect) error {
+	var drift []error
+	if options.Driver != "" && options.Driver != existing.Driver {
+		drift = append(drift, fmt.Errorf("driver %s, want %s", existing.Driver, options.Driver))
+	}
+	if options.Internal != existing.Internal {
+		drift = append(drift, fmt.Errorf("internal %v, want %v", existing.Internal, options.Internal))
+	}
+	if options.EnableIPv6 != existing.EnableIPv6 {
+		drift = append(drift, fmt.Errorf("IPv6 %v, want %v", existing.EnableIPv6, options.EnableIPv6))
+	}
+	for key, value := range options.DriverOpts {
+		if existing.Options[key] != value {
+			drift = append(drift, fmt.Errorf("option %s=%q, want %q", key, existing.Options[key], value))
 		}
 	}
+	if options.IPAM != nil {
+		want, have := ipamConfigs(options.IPAM), ipamConfigs(&existing.IPAM)
+		if !slices.Equal(want, have) {
+			drift = append(drift, fmt.Errorf("IPAM %v, want %v", have, want))
+		}
+	}
+	return errors.Join(drift...)
+}
 
-	logger.LogNetworkNew(fmt.Sprintf("[+] Network %s already exists", inspectRes.Name))
-	return &Network{client: client, Name: inspectRes.Name, ID: inspectRes.ID, Options: options}
+// Sorted subnet, range and gateway of each IPAM config
+func ipamConfigs(ipam *network.IPAM) []string {
+	var configs []string
+	for _, config := range ipam.Config {
+		configs = append(configs, fmt.Sprintf("%s/%s/%s", config.Subnet, config.IPRange, config.Gateway))
+	}
+	slices.Sort(configs)
+	return configs
+}
+
+// Existing network with the options and labels Docker reports, not created by this process
+func fromInspect(client *client.Client, inspectRes network.Inspect) *Network {
+	return &Network{
+		client: client,
+		Name:   inspectRes.Name,
+		ID:     inspectRes.ID,
+		Options: Options{
+			Driver:     inspectRes.Driver,
+			DriverOpts: inspectRes.Options,
+			Internal:   inspectRes.Internal,
+			IPAM:       &inspectRes.IPAM,
+			EnableIPv6: inspectRes.EnableIPv6,
+			Labels:     inspectRes.Labels,
+		},
+	}
 }
 
 func (network *Network) GetConnection() *Connection {
-	return &Connection{Network: network, IPv4: nil}
+	return &Connection{Network: network}
+}
+
+// Lists networks of one simulation run, or of every run if runID is empty
+func ListNetworks(client *client.Client, runID string) ([]*Network, error) {
+	list, err := client.Cli.NetworkList(client.Ctx, network.ListOptions{Filters: labels.Filter(runID)})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list networks: %w.", err)
+	}
+
+	var networks []*Network
+	for _, summary := range list {
+		networks = append(networks, fromInspect(client, summary))
+	}
+
+	return networks, nil
 }
@@ -0,0 +1,43 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestConfigDrift(t *testing.T) {
+	existing := network.Inspect{
+		Driver:  "macvlan",
+		Options: map[string]string{"macvlan_mode": "bridge", "parent": "dm-0123456789ab"},
+		IPAM: network.IPAM{Config: []network.IPAMConfig{
+			{Subnet: "fd00:10:0:1::/64"},
+			{Subnet: "10.10.240.0/20", Gateway: "10.10.240.1"},
+		}},
+		EnableIPv6: true,
+	}
+
+	same := Options{
+		Driver:     "macvlan",
+		DriverOpts: map[string]string{"macvlan_mode": "bridge"},
+		IPAM: &network.IPAM{Config: []network.IPAMConfig{
+			{Subnet: "10.10.240.0/20", Gateway: "10.10.240.1"},
+			{Subnet: "fd00:10:0:1::/64"},
+		}},
+		EnableIPv6: true,
+	}
+	if err := configDrift(same, existing); err != nil {
+		t.Errorf("Expected no drift, got %v", err)
+	}
+
+	changed := same
+	changed.Driver = "ipvlan"
+	changed.IPAM = &network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.10.0.0/20"}}}
+	err := configDrift(changed, existing)
+	if err == nil {
+		t.Fatal("Expected drift for driver and IPAM")
+	}
+	if drift, ok := err.(interface{ Unwrap() []error }); !ok || len(drift.Unwrap()) != 2 {
+		t.Errorf("Expected two differences, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Preset driver configuration, set in Options instead of Driver
+type Profile string
+
+const (
+	ProfileBridge   Profile = "bridge"
+	ProfileInternal Profile = "internal" // Bridge without a route out of the host
+	ProfileMacvlan  Profile = "macvlan"
+	ProfileIPvlanL2 Profile = "ipvlan-l2"
+	ProfileIPvlanL3 Profile = "ipvlan-l3"
+)
+
+// Parent that resolves to the interface of the host's default route
+const ParentAuto = "auto"
+
+const mtuOption = "com.docker.network.driver.mtu"
+
+// Driver, driver options and internal flag of the profile
+func (profile Profile) driverConfig(parent string, mtu int) (string, map[string]string, bool, error) {
+	driverOpts := make(map[string]string)
+	if mtu > 0 {
+		driverOpts[mtuOption] = strconv.Itoa(mtu)
+	}
+
+	if parent == ParentAuto {
+		defaultInterface, err := DefaultInterface()
+		if err != nil {
+			return "", nil, false, err
+		}
+		parent = defaultInterface
+	}
+
+	switch profile {
+	case ProfileBridge:
+		return "bridge", driverOpts, false, nil
+	case ProfileInternal:
+		return "bridge", driverOpts, true, nil
+	case ProfileMacvlan:
+		driverOpts["macvlan_mode"] = "bridge"
+		if parent != "" {
+			driverOpts["parent"] = parent
+		}
+		return "macvlan", driverOpts, false, nil
+	case ProfileIPvlanL2, ProfileIPvlanL3:
+		driverOpts["ipvlan_mode"] = strings.TrimPrefix(string(profile), "ipvlan-")
+		if parent != "" {
+			driverOpts["parent"] = parent
+		}
+		return "ipvlan", driverOpts, false, nil
+	}
+
+	return "", nil, false, fmt.Errorf("Unknown network profile %q.", profile)
+}
+
+// Host interface carrying the network's traffic: the bridge, or the dummy link Docker creates
+// for macvlan and ipvlan networks without a parent. With a parent, containers talk to each other
+// inside the driver and the parent only carries unrelated host traffic, so there is nothing to
+// capture on.
+func (network *Network) CaptureInterface() (string, error) {
+	id := network.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+
+	driverOpts := network.Options.DriverOpts
+	switch network.Options.Driver {
+	case "bridge":
+		if name := driverOpts["com.docker.network.bridge.name"]; name != "" {
+			return name, nil
+		}
+		return fmt.Sprintf("br-%v", id), nil
+	case "macvlan", "ipvlan":
+		if parent := driverOpts["parent"]; parent != "" {
+			return "", fmt.Errorf("%w: %v is a %v network on parent %v, use a profile without a parent to capture.", ErrNoCaptureInterface, network.Name, network.Options.Driver, parent)
+		}
+		if network.Options.Driver == "macvlan" {
+			return fmt.Sprintf("dm-%v", id), nil
+		}
+		return fmt.Sprintf("di-%v", id), nil
+	}
+
+	return "", fmt.Errorf("%w: %v uses driver %q.", ErrNoCaptureInterface, network.Name, network.Options.Driver)
+}
+
+// Interface of the IPv4 default route
+func DefaultInterface() (string, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", fmt.Errorf("Failed to read routes: %w.", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[1] == "00000000" {
+			return fields[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("No default route found.")
+}
@@ -0,0 +1,68 @@
+package network
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProfileDriverConfig(t *testing.T) {
+	tests := []struct {
+		profile  Profile
+		driver   string
+		mode     string
+		internal bool
+	}{
+		{ProfileBridge, "bridge", "", false},
+		{ProfileInternal, "bridge", "", true},
+		{ProfileMacvlan, "macvlan", "bridge", false},
+		{ProfileIPvlanL2, "ipvlan", "l2", false},
+		{ProfileIPvlanL3, "ipvlan", "l3", false},
+	}
+
+	for _, test := range tests {
+		driver, opts, internal, err := test.profile.driverConfig("eth0", 1400)
+		if err != nil {
+			t.Fatalf("%v: %v", test.profile, err)
+		}
+		if driver != test.driver || internal != test.internal {
+			t.Errorf("%v: got driver %v internal %v", test.profile, driver, internal)
+		}
+		if mode := opts["macvlan_mode"] + opts["ipvlan_mode"]; mode != test.mode {
+			t.Errorf("%v: got mode %q, want %q", test.profile, mode, test.mode)
+		}
+		if opts[mtuOption] != "1400" {
+			t.Errorf("%v: got MTU %q", test.profile, opts[mtuOption])
+		}
+	}
+
+	if _, _, _, err := Profile("overlay").driverConfig("", 0); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+func TestCaptureInterface(t *testing.T) {
+	id := "0123456789abcdef"
+	tests := []struct {
+		options Options
+		want    string
+	}{
+		{Options{Driver: "bridge"}, "br-0123456789ab"},
+		{Options{Driver: "macvlan"}, "dm-0123456789ab"},
+		{Options{Driver: "ipvlan"}, "di-0123456789ab"},
+		{Options{Driver: "bridge", DriverOpts: map[string]string{"com.docker.network.bridge.name": "sim0"}}, "sim0"},
+	}
+
+	for _, test := range tests {
+		net := Network{ID: id, Options: test.options}
+		if got, err := net.CaptureInterface(); err != nil || got != test.want {
+			t.Errorf("%v: got %v (%v), want %v", test.options.Driver, got, err, test.want)
+		}
+	}
+
+	for _, driver := range []string{"macvlan", "ipvlan"} {
+		net := Network{ID: id, Options: Options{Driver: driver, DriverOpts: map[string]string{"parent": "eth1"}}}
+		if _, err := net.CaptureInterface(); !errors.Is(err, ErrNoCaptureInterface) {
+			t.Errorf("%v with a parent: expected ErrNoCaptureInterface, got %v", driver, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package process
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// bytes.Buffer safe for the demultiplexer writing while the simulator reads
+type lockedBuffer struct {
+	buffer bytes.Buffer
+	mu     sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) readLines(delim byte) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := []string{}
+	for b.buffer.Len() != 0 {
+		line, err := b.buffer.ReadString(delim)
+
+		// Keep partial line until the rest arrives
+		if err != nil {
+			b.buffer.Reset()
+			b.buffer.WriteString(line)
+			break
+		}
+
+		if len(line) > 1 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Line of output stamped with the time its terminator arrived
+type Line struct {
+	Text    string
+	Arrived time.Time
+}
+
+// Splits stdout into lines as it arrives. Lines are buffered until read, notify is signalled when lines are added.
+type lineBuffer struct {
+	partial []byte
+	lines   []Line
+	notify  chan struct{}
+	mu      sync.Mutex
+}
+
+func newLineBuffer() *lineBuffer {
+	return &lineBuffer{notify: make(chan struct{}, 1)}
+}
+
+func (b *lineBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	rest := p
+	added := false
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			b.partial = append(b.partial, rest...)
+			break
+		}
+
+		text := string(append(b.partial, rest[:i+1]...))
+		b.partial = b.partial[:0]
+		rest = rest[i+1:]
+
+		// Skip empty lines
+		if len(text) > 1 {
+			b.lines = append(b.lines, Line{Text: text, Arrived: now})
+			added = true
+		}
+	}
+
+	if added {
+		select {
+		case b.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+func (b *lineBuffer) readLines() []Line {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := b.lines
+	b.lines = nil
+	return lines
+}
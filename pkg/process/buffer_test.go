@@ -0,0 +1,29 @@
+package process
+
+import "testing"
+
+func TestLineBufferSplitsPartialWrites(t *testing.T) {
+	b := newLineBuffer()
+	b.Write([]byte("Regular 3:hel"))
+	if lines := b.readLines(); len(lines) != 0 {
+		t.Fatalf("Partial line returned: %v", lines)
+	}
+
+	b.Write([]byte("lo\n\nDeniable 4:x\nRegular"))
+	lines := b.readLines()
+	if len(lines) != 2 || lines[0].Text != "Regular 3:hello\n" || lines[1].Text != "Deniable 4:x\n" {
+		t.Fatalf("Unexpected lines: %v", lines)
+	}
+
+	select {
+	case <-b.notify:
+	default:
+		t.Fatal("Buffered lines were not signalled")
+	}
+
+	b.Write([]byte(" 5:y\n"))
+	<-b.notify
+	if lines := b.readLines(); len(lines) != 1 || lines[0].Text != "Regular 5:y\n" || lines[0].Arrived.IsZero() {
+		t.Fatalf("Unexpected lines: %v", lines)
+	}
+}
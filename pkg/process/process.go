@@ -1,95 +1,294 @@
 package process
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"runtime"
 	"sync"
+	"time"
 )
 
 var (
 	processSem chan struct{} = make(chan struct{}, runtime.NumCPU())
 )
 
+const linesBufferSize = 1024
+
+var ErrProcessExited = errors.New("process exited and was not restarted")
+
+// Starts a new session running the commands, used when the current one dies
+type StartFunc func(commands []string) (*Session, error)
+
+type Options struct {
+	Restart RestartPolicy
+	OnExit  func(Event) // Optional, called every time a session exits
+}
+
 type Process struct {
-	conn     net.Conn
-	buffer   *bytes.Buffer
+	session  *Session
 	commands []string
-	execFunc func([]string, bool) (*Process, error)
+	start    StartFunc
+	options  Options
+	restarts int
+	closed   bool
+	changed  chan struct{} // Closed when the session is replaced or the process is done
+	lines    chan Line
+	stream   bool
+	done     chan struct{}
+	status   Status
+	err      error
 	mu       sync.Mutex
+	writeMu  sync.Mutex
 }
 
-func NewProcess(conn net.Conn, reader *bytes.Buffer, commands []string, execFunc func([]string, bool) (*Process, error)) *Process {
-	return &Process{conn, reader, commands, execFunc, sync.Mutex{}}
+func NewProcess(session *Session, commands []string, start StartFunc, options Options) *Process {
+	process := &Process{
+		session:  session,
+		commands: commands,
+		start:    start,
+		options:  options,
+		changed:  make(chan struct{}),
+		lines:    make(chan Line, linesBufferSize),
+		done:     make(chan struct{}),
+	}
+
+	go process.supervise()
+	return process
 }
 
+// Writes to the current session, waiting out a restart if the write fails
 func (process *Process) Cmd(cmd []byte) error {
-	processSem <- struct{}{}
-	defer func() { <-processSem }()
+	process.writeMu.Lock()
+	defer process.writeMu.Unlock()
 
-	process.mu.Lock()
-	defer process.mu.Unlock()
+	for {
+		process.mu.Lock()
+		session, changed := process.session, process.changed
+		process.mu.Unlock()
 
-	_, err := process.conn.Write(cmd)
-	if err != nil {
-		err := process.retry(cmd)
-		if err != nil {
-			return fmt.Errorf("Failed to retry process: %w.", err)
+		err := session.write(cmd)
+		if err == nil {
+			return nil
+		}
+
+		// Make sure the session ends so the supervisor restarts it
+		session.conn.Close()
+		<-changed
+
+		select {
+		case <-process.done:
+			return fmt.Errorf("Failed to write to process: %w.", ErrProcessExited)
+		default:
 		}
 	}
-	return nil
 }
 
-func (process *Process) Read(delim byte) []string {
+// Reads complete stdout lines buffered since the last read
+func (process *Process) Read() []string {
+	lines := process.ReadLines()
+
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	return texts
+}
+
+// Reads complete stdout lines buffered since the last read, with their arrival times
+func (process *Process) ReadLines() []Line {
 	processSem <- struct{}{}
 	defer func() { <-processSem }()
 
 	process.mu.Lock()
 	defer process.mu.Unlock()
 
-	lines := []string{}
-	for process.buffer.Len() != 0 {
-		line, err := process.buffer.ReadString(delim)
-		if len(line) > 1 {
-			lines = append(lines, line)
+	return process.session.stdout.readLines()
+}
+
+// Streams stdout lines as they arrive instead of buffering them for Read. The channel is closed when the process is done.
+func (process *Process) Lines() <-chan Line {
+	process.mu.Lock()
+	defer process.mu.Unlock()
+
+	if !process.stream {
+		process.stream = true
+		go process.forward()
+	}
+	return process.lines
+}
+
+// Moves lines from each session's buffer to the stream channel, never while holding a lock.
+// Lines nobody receives once the process is done are dropped.
+func (process *Process) forward() {
+	defer close(process.lines)
+
+	for {
+		process.mu.Lock()
+		session, changed := process.session, process.changed
+		process.mu.Unlock()
+
+		for exited := false; !exited; {
+			select {
+			case <-session.stdout.notify:
+			case <-session.exited:
+				exited = true
+			}
+
+			for _, line := range session.stdout.readLines() {
+				if !process.send(line) {
+					return
+				}
+			}
 		}
 
-		// Read until EOF
-		if err != nil {
-			break
+		// Closed when the session is replaced or the process is done
+		<-changed
+		select {
+		case <-process.done:
+			return
+		default:
 		}
 	}
-	return lines
 }
 
-func (process *Process) Close() error {
-	if process.conn != nil {
-		return process.conn.Close()
+func (process *Process) send(line Line) bool {
+	select {
+	case process.lines <- line:
+		return true
+	default:
+	}
+
+	select {
+	case process.lines <- line:
+		return true
+	case <-process.done:
+		return false
+	}
+}
+
+// Reads complete lines from stderr
+func (process *Process) ReadStderr(delim byte) []string {
+	process.mu.Lock()
+	defer process.mu.Unlock()
+
+	return process.session.stderr.readLines(delim)
+}
+
+// Live status of the current session
+func (process *Process) Status() (Status, error) {
+	process.mu.Lock()
+	session := process.session
+	process.mu.Unlock()
+
+	return session.inspect()
+}
+
+// Closed when the process has exited and will not be restarted
+func (process *Process) Done() <-chan struct{} {
+	return process.done
+}
+
+// Blocks until the process is done and returns its final status
+func (process *Process) Wait() (Status, error) {
+	<-process.done
+	return process.status, process.err
+}
+
+func (process *Process) Restarts() int {
+	process.mu.Lock()
+	defer process.mu.Unlock()
+	return process.restarts
+}
+
+// Writes a final command, e.g. "quit", without restarting the process when it exits
+func (process *Process) Shutdown(cmd []byte) error {
+	process.mu.Lock()
+	process.closed = true
+	session := process.session
+	process.mu.Unlock()
+
+	process.writeMu.Lock()
+	defer process.writeMu.Unlock()
+
+	if _, err := session.conn.Write(cmd); err != nil {
+		return fmt.Errorf("Failed to write shutdown command: %w.", err)
 	}
 	return nil
 }
 
-func (process *Process) retry(cmd []byte) error {
-	process.Close()
+func (process *Process) Close() error {
+	process.mu.Lock()
+	process.closed = true
+	session := process.session
+	process.mu.Unlock()
 
-	newProcess, err := process.execFunc(process.commands, true)
-	if err != nil {
-		return fmt.Errorf("Failed to create new process: %w.", err)
+	if session.conn != nil {
+		return session.conn.Close()
 	}
-	log.Printf("New process created: %v.\n", process.commands)
+	return nil
+}
+
+func (process *Process) supervise() {
+	for {
+		process.mu.Lock()
+		session := process.session
+		process.mu.Unlock()
+
+		<-session.exited
+
+		process.mu.Lock()
+		restarts := process.restarts
+		restart := !process.closed && process.options.Restart.shouldRestart(session.status, session.err, restarts)
+		process.mu.Unlock()
 
-	process.conn = newProcess.conn
-	process.buffer = newProcess.buffer
-	process.commands = newProcess.commands
-	process.execFunc = newProcess.execFunc
+		process.emit(Event{
+			Commands:   process.commands,
+			Status:     session.status,
+			Err:        session.err,
+			Restarts:   restarts,
+			Restarting: restart,
+			Timestamp:  time.Now(),
+		})
+
+		if !restart {
+			process.finish(session.status, session.err)
+			return
+		}
+
+		time.Sleep(process.options.Restart.backoff(restarts))
+
+		newSession, err := process.start(process.commands)
+		if err != nil {
+			process.finish(session.status, fmt.Errorf("Failed to create new process: %w.", err))
+			return
+		}
+		log.Printf("New process created: %v.\n", process.commands)
 
-	_, err = process.conn.Write(cmd)
-	if err != nil {
-		return fmt.Errorf("New process failed to write: %w.", err)
+		process.mu.Lock()
+		if process.closed {
+			newSession.conn.Close()
+		}
+		process.restarts++
+		process.session = newSession
+		close(process.changed)
+		process.changed = make(chan struct{})
+		process.mu.Unlock()
 	}
-	log.Printf("New process write.")
+}
 
-	return nil
+func (process *Process) finish(status Status, err error) {
+	process.mu.Lock()
+	defer process.mu.Unlock()
+
+	process.status = status
+	process.err = err
+	close(process.done)
+	close(process.changed)
+}
+
+func (process *Process) emit(event Event) {
+	if process.options.OnExit != nil {
+		process.options.OnExit(event)
+	}
 }
@@ -0,0 +1,159 @@
+package process
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Session whose commands are discarded. Its stream ends when the connection is closed.
+func fakeSession(exitCode int) *Session {
+	conn, peer := net.Pipe()
+	reader, writer := io.Pipe()
+	go func() {
+		io.Copy(io.Discard, peer)
+		writer.Close()
+	}()
+
+	inspect := func() (Status, error) { return Status{ExitCode: exitCode}, nil }
+	return NewSession(conn, reader, inspect, Output{})
+}
+
+func waitDone(t *testing.T, process *Process) {
+	t.Helper()
+	select {
+	case <-process.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Process not done")
+	}
+}
+
+func TestSuperviseRestartsUpToLimit(t *testing.T) {
+	var mu sync.Mutex
+	var events []Event
+
+	start := func([]string) (*Session, error) {
+		session := fakeSession(1)
+		session.conn.Close()
+		return session, nil
+	}
+	first, _ := start(nil)
+
+	process := NewProcess(first, []string{"./client"}, start, Options{
+		Restart: RestartPolicy{Mode: RestartOnFailure, MaxRestarts: 2, Backoff: time.Millisecond},
+		OnExit: func(event Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+		},
+	})
+	waitDone(t, process)
+
+	if restarts := process.Restarts(); restarts != 2 {
+		t.Errorf("Got %d restarts, want 2", restarts)
+	}
+	if status, _ := process.Wait(); status.ExitCode != 1 {
+		t.Errorf("Got final exit code %d, want 1", status.ExitCode)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 3 || !events[0].Restarting || !events[1].Restarting || events[2].Restarting {
+		t.Errorf("Unexpected exit events: %+v", events)
+	}
+	if _, ok := <-process.Lines(); ok {
+		t.Error("Lines should be closed once the process is done")
+	}
+}
+
+func TestSuperviseStopsOnClose(t *testing.T) {
+	started := 0
+	start := func([]string) (*Session, error) {
+		started++
+		return fakeSession(0), nil
+	}
+
+	process := NewProcess(fakeSession(0), nil, start, Options{Restart: RestartPolicy{Mode: RestartAlways}})
+	if err := process.Cmd([]byte("read\n")); err != nil {
+		t.Fatalf("Cmd failed: %v", err)
+	}
+
+	process.Close()
+	waitDone(t, process)
+
+	if started != 0 || process.Restarts() != 0 {
+		t.Errorf("Closed process was restarted %d times", started)
+	}
+}
+
+func TestCmdReleasesSemaphoreDuringRestart(t *testing.T) {
+	start := func([]string) (*Session, error) { return fakeSession(0), nil }
+	dead := fakeSession(1)
+	dead.conn.Close()
+
+	process := NewProcess(dead, nil, start, Options{Restart: RestartPolicy{Mode: RestartOnFailure, Backoff: 300 * time.Millisecond}})
+	defer process.Close()
+
+	// More writers than semaphore slots wait for the restart
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := process.Cmd([]byte("read\n")); err != nil {
+				t.Errorf("Cmd failed: %v", err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	read := make(chan struct{})
+	go func() {
+		process.ReadLines()
+		close(read)
+	}()
+	select {
+	case <-read:
+	case <-time.After(100 * time.Millisecond):
+		t.Error("ReadLines blocked while Cmd awaited a restart")
+	}
+
+	wg.Wait()
+	if restarts := process.Restarts(); restarts != 1 {
+		t.Errorf("Got %d restarts, want 1", restarts)
+	}
+}
+
+func TestLinesWithStalledReceiver(t *testing.T) {
+	output := strings.Repeat("Regular 3:hello\n", 2*linesBufferSize)
+	inspect := func() (Status, error) { return Status{}, nil }
+	session := NewSession(nil, multiplexed(t, output, ""), inspect, Output{})
+
+	process := NewProcess(session, nil, nil, Options{Restart: RestartPolicy{Mode: RestartNever}})
+	lines := process.Lines()
+	waitDone(t, process)
+
+	read := make(chan struct{})
+	go func() {
+		process.ReadLines()
+		close(read)
+	}()
+	select {
+	case <-read:
+	case <-time.After(time.Second):
+		t.Fatal("ReadLines blocked behind a full line stream")
+	}
+
+	// Lines beyond the channel buffer are dropped once the process is done
+	received := 0
+	for range lines {
+		received++
+	}
+	if received == 0 || received > 2*linesBufferSize {
+		t.Errorf("Received %d lines", received)
+	}
+}
@@ -0,0 +1,74 @@
+package process
+
+import "time"
+
+type RestartMode int
+
+const (
+	RestartNever RestartMode = iota
+	RestartOnFailure
+	RestartAlways
+)
+
+func (mode RestartMode) String() string {
+	switch mode {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	}
+	return "unknown"
+}
+
+// MaxRestarts of 0 allows unlimited restarts. Backoff doubles per restart up to MaxBackoff.
+type RestartPolicy struct {
+	Mode        RestartMode
+	MaxRestarts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		Mode:       RestartOnFailure,
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+func (policy RestartPolicy) shouldRestart(status Status, err error, restarts int) bool {
+	if policy.MaxRestarts > 0 && restarts >= policy.MaxRestarts {
+		return false
+	}
+
+	switch policy.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil || status.ExitCode != 0
+	}
+	return false
+}
+
+func (policy RestartPolicy) backoff(restarts int) time.Duration {
+	backoff := policy.Backoff
+	for range restarts {
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff >= policy.MaxBackoff {
+			return policy.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+// Emitted every time the process exits. Restarting is false for the final exit.
+type Event struct {
+	Commands   []string
+	Status     Status
+	Err        error
+	Restarts   int
+	Restarting bool
+	Timestamp  time.Time
+}
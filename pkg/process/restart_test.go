@@ -0,0 +1,50 @@
+package process
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRestartPolicyLimits(t *testing.T) {
+	failed := Status{ExitCode: 1}
+	errStream := errors.New("stream closed")
+
+	tests := []struct {
+		name     string
+		policy   RestartPolicy
+		status   Status
+		err      error
+		restarts int
+		want     bool
+	}{
+		{"never", RestartPolicy{Mode: RestartNever}, failed, nil, 0, false},
+		{"on-failure clean exit", RestartPolicy{Mode: RestartOnFailure}, Status{}, nil, 0, false},
+		{"on-failure exit code", RestartPolicy{Mode: RestartOnFailure}, failed, nil, 0, true},
+		{"on-failure error", RestartPolicy{Mode: RestartOnFailure}, Status{}, errStream, 0, true},
+		{"always clean exit", RestartPolicy{Mode: RestartAlways}, Status{}, nil, 5, true},
+		{"below limit", RestartPolicy{Mode: RestartAlways, MaxRestarts: 3}, Status{}, nil, 2, true},
+		{"at limit", RestartPolicy{Mode: RestartAlways, MaxRestarts: 3}, Status{}, nil, 3, false},
+	}
+
+	for _, test := range tests {
+		if got := test.policy.shouldRestart(test.status, test.err, test.restarts); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRestartPolicyBackoff(t *testing.T) {
+	policy := RestartPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for restarts, backoff := range want {
+		if got := policy.backoff(restarts); got != backoff {
+			t.Errorf("Restart %d: got %v, want %v", restarts, got, backoff)
+		}
+	}
+
+	unbounded := RestartPolicy{Backoff: time.Second}
+	if got := unbounded.backoff(6); got != 64*time.Second {
+		t.Errorf("Without MaxBackoff got %v, want 64s", got)
+	}
+}
@@ -0,0 +1,93 @@
+package process
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Liveness of an exec'd process as reported by Docker
+type Status struct {
+	Running  bool
+	ExitCode int
+	Pid      int
+}
+
+type InspectFunc func() (Status, error)
+
+// Optional copies of the process output. OnClose is called when the exec stream ends.
+type Output struct {
+	Stdout  io.Writer
+	Stderr  io.Writer
+	OnClose func(error)
+}
+
+// A single exec of the process commands. A Process moves to a new session on restart.
+type Session struct {
+	conn    net.Conn
+	stdout  *lineBuffer
+	stderr  *lockedBuffer
+	inspect InspectFunc
+	exited  chan struct{}
+	status  Status
+	err     error
+}
+
+// Reader is the multiplexed stream of a non-TTY Docker exec. It is split into separate stdout and stderr buffers.
+func NewSession(conn net.Conn, reader io.Reader, inspect InspectFunc, output Output) *Session {
+	session := &Session{
+		conn:    conn,
+		stdout:  newLineBuffer(),
+		stderr:  &lockedBuffer{},
+		inspect: inspect,
+		exited:  make(chan struct{}),
+	}
+
+	go session.demultiplex(reader, output)
+	return session
+}
+
+func (session *Session) demultiplex(reader io.Reader, output Output) {
+	stdout := []io.Writer{session.stdout}
+	if output.Stdout != nil {
+		stdout = append(stdout, output.Stdout)
+	}
+
+	stderr := []io.Writer{session.stderr}
+	if output.Stderr != nil {
+		stderr = append(stderr, output.Stderr)
+	}
+
+	_, err := stdcopy.StdCopy(io.MultiWriter(stdout...), io.MultiWriter(stderr...), reader)
+	if output.OnClose != nil {
+		output.OnClose(err)
+	}
+
+	session.status, session.err = session.waitStatus()
+	close(session.exited)
+}
+
+// Holds the semaphore only for the write itself, not while a restart is awaited
+func (session *Session) write(cmd []byte) error {
+	processSem <- struct{}{}
+	defer func() { <-processSem }()
+
+	_, err := session.conn.Write(cmd)
+	return err
+}
+
+// The exec can still be reported as running right after its stream closes
+func (session *Session) waitStatus() (Status, error) {
+	var status Status
+	var err error
+	for range 20 {
+		status, err = session.inspect()
+		if err != nil || !status.Running {
+			return status, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return status, err
+}
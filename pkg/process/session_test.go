@@ -0,0 +1,56 @@
+package process
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Multiplexes stdout and stderr the way a non-TTY Docker exec does
+func multiplexed(t *testing.T, stdout, stderr string) io.Reader {
+	t.Helper()
+
+	var stream bytes.Buffer
+	if _, err := stdcopy.NewStdWriter(&stream, stdcopy.Stdout).Write([]byte(stdout)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdcopy.NewStdWriter(&stream, stdcopy.Stderr).Write([]byte(stderr)); err != nil {
+		t.Fatal(err)
+	}
+	return &stream
+}
+
+func TestSessionDemultiplexes(t *testing.T) {
+	var stderrLog bytes.Buffer
+	closed := make(chan error, 1)
+	output := Output{Stderr: &stderrLog, OnClose: func(err error) { closed <- err }}
+	inspect := func() (Status, error) { return Status{ExitCode: 3, Pid: 42}, nil }
+
+	session := NewSession(nil, multiplexed(t, "Regular 3:hello\nDeniable 4:x\n", "warning: slow\n"), inspect, output)
+
+	select {
+	case <-session.exited:
+	case <-time.After(time.Second):
+		t.Fatal("Session did not exit when the stream ended")
+	}
+	if err := <-closed; err != nil {
+		t.Errorf("Unexpected stream error: %v", err)
+	}
+
+	lines := session.stdout.readLines()
+	if len(lines) != 2 || lines[0].Text != "Regular 3:hello\n" || lines[1].Text != "Deniable 4:x\n" {
+		t.Errorf("Unexpected stdout lines: %v", lines)
+	}
+	if stderr := session.stderr.readLines('\n'); len(stderr) != 1 || stderr[0] != "warning: slow\n" {
+		t.Errorf("Unexpected stderr lines: %v", stderr)
+	}
+	if stderrLog.String() != "warning: slow\n" {
+		t.Errorf("Stderr log got %q", stderrLog.String())
+	}
+	if session.status.ExitCode != 3 || session.status.Pid != 42 {
+		t.Errorf("Unexpected status: %+v", session.status)
+	}
+}
@@ -1,6 +1,7 @@
 package Behavior
 
 import (
+	Messagemaker "deniable-im/im-sim/pkg/simulation/messagemaker"
 	Types "deniable-im/im-sim/pkg/simulation/types"
 	"fmt"
 	"math/rand"
@@ -40,18 +41,16 @@ func GenerateRealisticSimpleHumanTraits(count int, r *rand.Rand, nfunc func(*Sim
 	fmt.Printf("Avg send: %v \n", goodSendAvg)
 	fmt.Printf("Avg reply: %v \n", goodReplyAvg)
 
+	if r == nil {
+		r = rand.New(rand.NewSource(rand.Int63()))
+	}
+
 	for i := range traits {
-		var send, reply float64
-		var rand_param *rand.Rand
-		if r != nil {
-			send = r.Float64()*MAX_MIN_DIFF + (goodSendAvg - MAX_MIN_DIFF/2)
-			reply = r.Float64()*MAX_MIN_DIFF + (goodReplyAvg - MAX_MIN_DIFF/2)
-			rand_param = r
-		} else {
-			send = rand.Float64()*MAX_MIN_DIFF + (goodSendAvg - MAX_MIN_DIFF/2)
-			reply = rand.Float64()*MAX_MIN_DIFF + (goodReplyAvg - MAX_MIN_DIFF/2)
-			rand_param = rand.New(rand.NewSource(rand.Int63()))
-		}
+		send := r.Float64()*MAX_MIN_DIFF + (goodSendAvg - MAX_MIN_DIFF/2)
+		reply := r.Float64()*MAX_MIN_DIFF + (goodReplyAvg - MAX_MIN_DIFF/2)
+		// Own source per user so the schedule does not depend on goroutine order
+		user_seed := r.Int63()
+		rand_param := rand.New(rand.NewSource(user_seed))
 		fmt.Printf("Pre increment send: %v, reply: %v \n", send, reply)
 		for reply <= send {
 			println("Incrementing reply...")
@@ -63,6 +62,9 @@ func GenerateRealisticSimpleHumanTraits(count int, r *rand.Rand, nfunc func(*Sim
 		burst_mod := 0.1
 		burst_len := 5
 		traits[i] = NewSimpleHumanTraits(fmt.Sprintf("%v", i), send, reply, deniable_rate, burst_mod, int32(burst_len), nfunc, rand_param)
+
+		media_param := mediaSource(user_seed)
+		traits[i].SetMedia(ScaleMediaProfiles(DefaultMediaProfiles(), media_param.Float64()+0.5), media_param)
 	}
 
 	return traits
@@ -79,19 +81,33 @@ func GenerateSimpleHumanTraitsFromOptions(count int, nextfunc func(*SimpleHumanT
 	MaxMinDenDiff := options.MinMaxDeniableProbability.Second - options.MinMaxDeniableProbability.First
 	MaxMinReplyDiff := options.MinMaxReplyProbability.Second - options.MinMaxReplyProbability.First
 
-	var rand_param *rand.Rand
+	var r *rand.Rand
 	if options.Seed != nil {
-		rand_param = rand.New(rand.NewSource(*options.Seed))
+		r = rand.New(rand.NewSource(*options.Seed))
 	} else {
-		rand_param = rand.New(rand.NewSource(rand.Int63()))
+		r = rand.New(rand.NewSource(rand.Int63()))
 	}
 
 	for i := range traits {
+		user_seed := r.Int63()
+		rand_param := rand.New(rand.NewSource(user_seed))
 		send := rand_param.Float64()*MaxMinRegularDiff + options.MinMaxRegularProbabiity.First
 		den := rand_param.Float64()*MaxMinDenDiff + options.MinMaxDeniableProbability.First
 		reply := rand_param.Float64()*MaxMinReplyDiff + options.MinMaxReplyProbability.First
 
 		traits[i] = NewSimpleHumanTraits(fmt.Sprintf("%v", i), send, reply, den, *options.BurstModifier, int32(*options.BurstSize), nextfunc, rand_param)
+
+		if options.MediaProfiles != nil {
+			media_param := mediaSource(user_seed)
+			scale := 1.0
+			if options.MinMaxMediaScale != nil {
+				scale = media_param.Float64()*(options.MinMaxMediaScale.Second-options.MinMaxMediaScale.First) + options.MinMaxMediaScale.First
+			}
+			traits[i].SetMedia(ScaleMediaProfiles(options.MediaProfiles, scale), media_param)
+		}
+		if options.ClientAttachments {
+			traits[i].AttachmentCommand = Messagemaker.DenimAttachmentCommand
+		}
 	}
 
 	return traits
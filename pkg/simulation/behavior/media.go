@@ -0,0 +1,60 @@
+package Behavior
+
+import (
+	Types "deniable-im/im-sim/pkg/simulation/types"
+	"math"
+	"math/rand"
+)
+
+// Mixed into a user's seed so media draws do not shift the behavior randomizer of seeded populations
+const mediaSeedSalt = 0x6d65646961
+
+func mediaSource(userSeed int64) *rand.Rand {
+	return rand.New(rand.NewSource(userSeed ^ mediaSeedSalt))
+}
+
+// Rough shares of media in regular chat traffic. Sizes are medians in bytes.
+func DefaultMediaProfiles() []Types.MediaProfile {
+	return []Types.MediaProfile{
+		{Kind: Types.Image, Prob: 0.08, MedianSize: 150_000, SizeSigma: 0.8, MaxSize: 5_000_000},
+		{Kind: Types.VoiceNote, Prob: 0.03, MedianSize: 40_000, SizeSigma: 0.7, MaxSize: 2_000_000},
+		{Kind: Types.File, Prob: 0.01, MedianSize: 500_000, SizeSigma: 1.2, MaxSize: 20_000_000},
+	}
+}
+
+// Scales the probability of every profile, used to give each user their own media habits
+func ScaleMediaProfiles(profiles []Types.MediaProfile, scale float64) []Types.MediaProfile {
+	scaled := make([]Types.MediaProfile, len(profiles))
+	for i, profile := range profiles {
+		profile.Prob = math.Min(1.0, profile.Prob*scale)
+		scaled[i] = profile
+	}
+	return scaled
+}
+
+// Picks at most one attachment kind from the profiles and draws its size. Returns nil if no media is sent.
+func MakeAttachment(profiles []Types.MediaProfile, r *rand.Rand) *Types.Attachment {
+	if len(profiles) == 0 || r == nil {
+		return nil
+	}
+
+	roll := r.Float64()
+	for _, profile := range profiles {
+		if roll >= profile.Prob {
+			roll -= profile.Prob
+			continue
+		}
+
+		size := int(math.Exp(math.Log(float64(profile.MedianSize)) + profile.SizeSigma*r.NormFloat64()))
+		if profile.MaxSize > 0 && size > profile.MaxSize {
+			size = profile.MaxSize
+		}
+		if size < 1 {
+			size = 1
+		}
+
+		return &Types.Attachment{Kind: profile.Kind, Size: size}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package Behavior
+
+import (
+	Types "deniable-im/im-sim/pkg/simulation/types"
+	"math/rand"
+	"testing"
+)
+
+func TestScaleMediaProfiles(t *testing.T) {
+	profiles := DefaultMediaProfiles()
+	scaled := ScaleMediaProfiles(profiles, 2)
+	if scaled[0].Prob != profiles[0].Prob*2 || scaled[0].MedianSize != profiles[0].MedianSize {
+		t.Errorf("Unexpected scaled profile %+v", scaled[0])
+	}
+	if profiles[0].Prob != DefaultMediaProfiles()[0].Prob {
+		t.Error("Scaling modified the input profiles")
+	}
+	if capped := ScaleMediaProfiles(profiles, 100); capped[0].Prob != 1 {
+		t.Errorf("Probability not capped at 1, got %v", capped[0].Prob)
+	}
+}
+
+func TestMakeAttachment(t *testing.T) {
+	profiles := []Types.MediaProfile{{Kind: Types.File, Prob: 1, MedianSize: 1000, SizeSigma: 2, MaxSize: 2000}}
+	r := rand.New(rand.NewSource(1))
+	for range 100 {
+		attachment := MakeAttachment(profiles, r)
+		if attachment == nil || attachment.Kind != Types.File || attachment.Size < 1 || attachment.Size > 2000 {
+			t.Fatalf("Unexpected attachment %+v", attachment)
+		}
+	}
+
+	if attachment := MakeAttachment([]Types.MediaProfile{{Kind: Types.Image, Prob: 0}}, r); attachment != nil {
+		t.Errorf("Expected no attachment, got %+v", attachment)
+	}
+	if attachment := MakeAttachment(nil, r); attachment != nil {
+		t.Errorf("Expected no attachment without profiles, got %+v", attachment)
+	}
+}
+
+// Media must not draw from the behavior randomizer, or adding it changes existing seeded populations
+func TestMediaKeepsSeededTraits(t *testing.T) {
+	seed := int64(123456789)
+	burstMod, burstSize := 0.1, 5
+	options := Types.SimUserOptions{
+		MinMaxRegularProbabiity:   &Types.FloatTuple{First: 0.25, Second: 0.45},
+		MinMaxDeniableProbability: &Types.FloatTuple{First: 0.05, Second: 0.1},
+		MinMaxReplyProbability:    &Types.FloatTuple{First: 0.5, Second: 0.75},
+		BurstModifier:             &burstMod,
+		BurstSize:                 &burstSize,
+		Seed:                      &seed,
+	}
+	next := func(*SimpleHumanTraits) int { return 0 }
+
+	plain := GenerateSimpleHumanTraitsFromOptions(5, next, options)
+	options.MediaProfiles = DefaultMediaProfiles()
+	options.MinMaxMediaScale = &Types.FloatTuple{First: 0.5, Second: 1.5}
+	media := GenerateSimpleHumanTraitsFromOptions(5, next, options)
+
+	for i := range plain {
+		if plain[i].SendProp != media[i].SendProp || plain[i].ResponseProb != media[i].ResponseProb {
+			t.Errorf("User %d traits changed by media", i)
+		}
+		if plain[i].GetRandomizer().Int63() != media[i].GetRandomizer().Int63() {
+			t.Errorf("User %d randomizer changed by media", i)
+		}
+	}
+}
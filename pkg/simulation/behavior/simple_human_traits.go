@@ -21,8 +21,11 @@ type SimpleHumanTraits struct {
 	DeniableBurstSize int32
 	DeniableCount     int32
 	User              *Types.SimUser
+	Media             []Types.MediaProfile
+	AttachmentCommand string // Empty if the client has no attachment path
 	nextMsgFunc       func(*SimpleHumanTraits) int
 	randomizer        *rand.Rand
+	mediaRandomizer   *rand.Rand // Separate from randomizer so attachments do not change seeded schedules
 	nextSendTime      time.Time
 }
 
@@ -109,6 +112,12 @@ func (sh *SimpleHumanTraits) GetResponseTime() int {
 	return int(sh.randomizer.Int31n((time)))
 }
 
+// Media profiles and the randomizer their attachments are drawn from
+func (sh *SimpleHumanTraits) SetMedia(profiles []Types.MediaProfile, r *rand.Rand) {
+	sh.Media = profiles
+	sh.mediaRandomizer = r
+}
+
 func (sh *SimpleHumanTraits) IncrementDeniableCount() {
 	sh.DeniableCount += sh.DeniableBurstSize
 }
@@ -161,12 +170,17 @@ func (sh *SimpleHumanTraits) MakeMessages() []Types.Msg {
 		From:       fmt.Sprintf("%v", sh.User.ID),
 		MsgContent: Messagemaker.GetQuoteByIndexSafe(sh.randomizer.Int()),
 		IsDeniable: false,
+		Attachment: MakeAttachment(sh.Media, sh.mediaRandomizer),
 	}
 
 	msgs = append(msgs, reg_msg)
 
 	for i, msg := range msgs {
-		msgs[i] = Messagemaker.MakeDenimProtocolMessage(msg)
+		if msg.Attachment != nil && sh.AttachmentCommand != "" {
+			msgs[i] = Messagemaker.MakeDenimAttachmentMessage(msg, sh.AttachmentCommand)
+		} else {
+			msgs[i] = Messagemaker.MakeDenimProtocolMessage(msg)
+		}
 	}
 
 	return msgs
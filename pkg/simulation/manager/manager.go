@@ -6,12 +6,21 @@ import (
 	User "deniable-im/im-sim/pkg/simulation/simulator/user"
 	Types "deniable-im/im-sim/pkg/simulation/types"
 	"fmt"
+	"math/rand"
 )
 
 // Creates default user array of the specified size. Panics if there is not enough containers or the nextfunc is nil.
 func MakeDefaultSimulation(
 	count int, clientContainers []*container.Container,
 	nextfunc func(*Behavior.SimpleHumanTraits) int) []*User.SimulatedUser {
+	return MakeSeededDefaultSimulation(count, clientContainers, nextfunc, nil)
+}
+
+// Creates default user array from a seeded randomizer, unseeded if r is nil. Panics if there is not enough containers or the nextfunc is nil.
+func MakeSeededDefaultSimulation(
+	count int, clientContainers []*container.Container,
+	nextfunc func(*Behavior.SimpleHumanTraits) int,
+	r *rand.Rand) []*User.SimulatedUser {
 	if len(clientContainers) < count {
 		panic(fmt.Sprintf("Insufficient number of clientContainers provided as argument. Expected %v, got %v", count, len(clientContainers)))
 	}
@@ -21,7 +30,7 @@ func MakeDefaultSimulation(
 	}
 
 	users := make([]*User.SimulatedUser, count)
-	traits := Behavior.GenerateRealisticSimpleHumanTraits(count, nil, nextfunc)
+	traits := Behavior.GenerateRealisticSimpleHumanTraits(count, r, nextfunc)
 	for i := 0; i < count; i++ {
 		user := &Types.SimUser{
 			ID:       int32(i),
@@ -55,6 +64,9 @@ func MakeSimUsersFromOptions(
 
 	if options == nil || options.HasNil() {
 		println("Encountered nil, uses default")
+		if options != nil && options.Seed != nil {
+			return MakeSeededDefaultSimulation(count, clientContainers, nextfunc, rand.New(rand.NewSource(*options.Seed)))
+		}
 		return MakeDefaultSimulation(count, clientContainers, nextfunc)
 	}
 
@@ -0,0 +1,64 @@
+package Messagemaker
+
+import (
+	Types "deniable-im/im-sim/pkg/simulation/types"
+	"fmt"
+	"strings"
+)
+
+const attachmentFiller = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// Attachment command for a DenIM client with an attachment path, the client generates the payload
+// from the size. Only used with SimUserOptions.ClientAttachments, attachments are emulated otherwise.
+const DenimAttachmentCommand = "attach"
+
+// Prefix of the ID in the bodies of emulated attachment chunks, e.g. "+0000002a abcdef..."
+const AttachmentChunkTag = "+"
+
+// Largest filler body per line of an emulated attachment
+const EmulatedChunkSize = 64 * 1024
+
+// Attachment sent through a client that has its own attachment command, e.g. "attach:<to>:<kind>:<size>:<caption>"
+func MakeDenimAttachmentMessage(msg Types.Msg, command string) Types.Msg {
+	result := Types.Msg{
+		ID:         msg.ID,
+		To:         msg.To,
+		From:       msg.From,
+		IsDeniable: msg.IsDeniable,
+	}
+
+	if msg.Attachment == nil {
+		return MakeDenimProtocolMessage(msg)
+	}
+
+	if result.ID == "" {
+		result.ID = NewMessageID()
+	}
+
+	attachment := *msg.Attachment
+	attachment.Emulated = false
+	result.Attachment = &attachment
+	result.MsgContent = fmt.Sprintf("%v:%v:%v:%v:%v", command, msg.To, attachment.Kind, attachment.Size, withMessageID(result.ID, msg.MsgContent))
+
+	return result
+}
+
+// Lines sending the message followed by Size bytes of filler, split into messages of at most
+// EmulatedChunkSize to the same recipient. Used for clients without an attachment path.
+func EmulateAttachment(msg Types.Msg) []string {
+	lines := []string{msg.MsgContent}
+	if msg.Attachment == nil || msg.Attachment.Size <= 0 {
+		return lines
+	}
+
+	command := "send"
+	if msg.IsDeniable {
+		command = "denim"
+	}
+
+	filler := strings.Repeat(attachmentFiller, EmulatedChunkSize/len(attachmentFiller)+1)[:EmulatedChunkSize]
+	for remaining := msg.Attachment.Size; remaining > 0; remaining -= EmulatedChunkSize {
+		lines = append(lines, fmt.Sprintf("%v:%v:%v%v %v", command, msg.To, AttachmentChunkTag, msg.ID, filler[:min(remaining, EmulatedChunkSize)]))
+	}
+	return lines
+}
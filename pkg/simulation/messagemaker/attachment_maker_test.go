@@ -0,0 +1,46 @@
+package Messagemaker
+
+import (
+	Types "deniable-im/im-sim/pkg/simulation/types"
+	"strings"
+	"testing"
+)
+
+func TestEmulateAttachment(t *testing.T) {
+	msg := MakeDenimProtocolMessage(Types.Msg{To: "7", From: "3", MsgContent: "Zebras dream in barcode",
+		Attachment: &Types.Attachment{Kind: Types.Image, Size: 2*EmulatedChunkSize + 10}})
+
+	lines := EmulateAttachment(msg)
+	if len(lines) != 4 || lines[0] != msg.MsgContent {
+		t.Fatalf("Expected the message and three chunks, got %d lines", len(lines))
+	}
+
+	total := 0
+	for _, line := range lines[1:] {
+		prefix := "send:7:" + AttachmentChunkTag + msg.ID + " "
+		if !strings.HasPrefix(line, prefix) {
+			t.Fatalf("Unexpected chunk prefix %q", line[:len(prefix)])
+		}
+		if strings.Contains(line, "\n") {
+			t.Fatal("Chunk contains a line break")
+		}
+		total += len(line) - len(prefix)
+	}
+	if total != msg.Attachment.Size {
+		t.Errorf("Got %d filler bytes, want %d", total, msg.Attachment.Size)
+	}
+
+	if lines := EmulateAttachment(Types.Msg{MsgContent: "send:1:hi"}); len(lines) != 1 {
+		t.Errorf("Message without attachment got %d lines", len(lines))
+	}
+}
+
+func TestDenimAttachmentMessage(t *testing.T) {
+	msg := MakeDenimAttachmentMessage(Types.Msg{To: "7", From: "3", MsgContent: "caption",
+		Attachment: &Types.Attachment{Kind: Types.VoiceNote, Size: 40000}}, DenimAttachmentCommand)
+
+	want := "attach:7:voice:40000:" + MessageIDTag + msg.ID + " caption"
+	if msg.MsgContent != want || msg.Attachment.Emulated {
+		t.Errorf("Got %q, want %q", msg.MsgContent, want)
+	}
+}
@@ -7,15 +7,27 @@ import (
 
 func MakeDenimProtocolMessage(msg Types.Msg) Types.Msg {
 	result := Types.Msg{
+		ID:         msg.ID,
 		To:         msg.To,
 		From:       msg.From,
 		IsDeniable: msg.IsDeniable,
 	}
 
+	// Client has no attachment path, the body is padded when the message is sent
+	if msg.Attachment != nil {
+		attachment := *msg.Attachment
+		attachment.Emulated = true
+		result.Attachment = &attachment
+	}
+
+	if result.ID == "" {
+		result.ID = NewMessageID()
+	}
+
 	if msg.IsDeniable {
-		result.MsgContent = fmt.Sprintf("denim:%v:%v", msg.To, msg.MsgContent)
+		result.MsgContent = fmt.Sprintf("denim:%v:%v", msg.To, withMessageID(result.ID, msg.MsgContent))
 	} else {
-		result.MsgContent = fmt.Sprintf("send:%v:%v", msg.To, msg.MsgContent)
+		result.MsgContent = fmt.Sprintf("send:%v:%v", msg.To, withMessageID(result.ID, msg.MsgContent))
 	}
 
 	return result
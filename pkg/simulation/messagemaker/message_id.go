@@ -0,0 +1,20 @@
+package Messagemaker
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Prefix of the message ID embedded at the start of every body, e.g. "#0000002a Zebras dream in barcode"
+const MessageIDTag = "#"
+
+var messageCounter atomic.Uint64
+
+// Simulator-wide unique message ID
+func NewMessageID() string {
+	return fmt.Sprintf("%08x", messageCounter.Add(1))
+}
+
+func withMessageID(id, content string) string {
+	return fmt.Sprintf("%v%v %v", MessageIDTag, id, content)
+}
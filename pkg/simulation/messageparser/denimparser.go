@@ -2,44 +2,123 @@ package Messageparser
 
 import (
 	Types "deniable-im/im-sim/pkg/simulation/types"
-	"fmt"
+	"errors"
 	"strings"
 )
 
-func DenimParser(incoming string) (*Types.Msg, error) {
-	err := fmt.Errorf("Failed to parse incoming string")
+// Grammar of a line printed by the DenIM client:
+//
+//	line    = message | error | notice
+//	message = label SP sender ":" body
+//	label   = "Regular" | "Deniable"        ; case-insensitive
+//	sender  = 1*<any char except ":">       ; may contain spaces, e.g. "Unknown Sender"
+//	body    = *<any char>                   ; kept verbatim, colons included
+//	error   = panic | report | oserror
+//	panic   = "thread '" *<any char>        ; Rust panic message
+//	report  = "Error: " *<any char>         ; Rust error returned from main
+//	oserror = *<any char> "(os error " *<any char>  ; std::io error, e.g. a refused connection
+//	notice  = 1*<any char>                  ; everything else
+//
+// Message lines are the format the client prints received messages in. The error productions
+// come from the Rust runtime, not the client. Prefixes of client specific lines, e.g. sent
+// acknowledgements, belong here once they are seen in recorded transcripts, see testdata/.
+// The trailing line terminator is not part of the line.
 
-	splits := strings.Split(incoming, ":")
-	if splits[0] == "" || splits[0] == "\n" {
-		return nil, err
+type EventKind int
+
+const (
+	MessageEvent EventKind = iota
+	ErrorEvent
+	NoticeEvent
+)
+
+func (kind EventKind) String() string {
+	switch kind {
+	case MessageEvent:
+		return "Message"
+	case ErrorEvent:
+		return "Error"
+	case NoticeEvent:
+		return "Notice"
 	}
+	return "Unknown"
+}
 
-	sender := splits[0]
-	if len(sender) < 8 {
-		return nil, err
+type Event struct {
+	Kind EventKind
+	Msg  *Types.Msg // Only set for MessageEvent
+	Text string     // Line without terminator
+}
+
+var (
+	ErrEmptyLine  = errors.New("empty client output line")
+	ErrNotMessage = errors.New("client output line is not a message")
+)
+
+var errorPrefixes = []string{"thread '", "Error: "}
+
+func ParseDenimLine(line string) (*Event, error) {
+	text := strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(text) == "" {
+		return nil, ErrEmptyLine
+	}
+
+	if msg, ok := parseMessage(text); ok {
+		return &Event{Kind: MessageEvent, Msg: msg, Text: text}, nil
 	}
 
-	if len(splits) < 2 {
+	if hasAnyPrefix(text, errorPrefixes) || strings.Contains(text, "(os error ") {
+		return &Event{Kind: ErrorEvent, Text: text}, nil
+	}
+
+	return &Event{Kind: NoticeEvent, Text: text}, nil
+}
+
+// Parses a received message. Any other line is an error.
+func DenimParser(incoming string) (*Types.Msg, error) {
+	event, err := ParseDenimLine(incoming)
+	if err != nil {
 		return nil, err
 	}
 
-	isDeniable := strings.Contains(strings.ToLower(sender), "deniable")
+	if event.Kind != MessageEvent {
+		return nil, ErrNotMessage
+	}
 
-	if !isDeniable {
-		sender = sender[8:]
-		if sender == "\n" {
-			return nil, err
-		}
+	return event.Msg, nil
+}
+
+func parseMessage(text string) (*Types.Msg, bool) {
+	label, rest, found := strings.Cut(text, " ")
+	if !found {
+		return nil, false
 	}
 
-	var msg *Types.Msg
+	var isDeniable bool
+	switch strings.ToLower(label) {
+	case "regular":
+		isDeniable = false
+	case "deniable":
+		isDeniable = true
+	default:
+		return nil, false
+	}
 
-	if isDeniable {
-		sender = sender[9:]
-		msg = &Types.Msg{To: "", From: sender, MsgContent: splits[1], IsDeniable: true}
-	} else {
-		msg = &Types.Msg{To: "", From: sender, MsgContent: splits[1], IsDeniable: false}
+	sender, body, found := strings.Cut(rest, ":")
+	sender = strings.TrimSpace(sender)
+	if !found || sender == "" {
+		return nil, false
 	}
 
-	return msg, nil
+	id, content := ExtractMessageID(body)
+	return &Types.Msg{ID: id, From: sender, MsgContent: content, IsDeniable: isDeniable}, true
+}
+
+func hasAnyPrefix(text string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	return false
 }
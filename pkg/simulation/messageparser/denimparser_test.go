@@ -0,0 +1,111 @@
+package Messageparser
+
+import (
+	"bufio"
+	Messagemaker "deniable-im/im-sim/pkg/simulation/messagemaker"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDenimLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		kind       EventKind
+		from       string
+		id         string
+		content    string
+		isDeniable bool
+	}{
+		{line: "Regular 3:hello\n", kind: MessageEvent, from: "3", content: "hello"},
+		{line: "Deniable 7:#0000002b kumquat\n", kind: MessageEvent, from: "7", id: "0000002b", content: "kumquat", isDeniable: true},
+		{line: "Regular 4:#0000002d a: b: c\n", kind: MessageEvent, from: "4", id: "0000002d", content: "a: b: c"},
+		{line: "Regular Unknown Sender:hi\n", kind: MessageEvent, from: "Unknown Sender", content: "hi"},
+		{line: "thread 'main' panicked at src/main.rs:10:5:\n", kind: ErrorEvent},
+		{line: "Error: Os { code: 111, kind: ConnectionRefused, message: \"Connection refused\" }\n", kind: ErrorEvent},
+		{line: "Connection refused (os error 111)\n", kind: ErrorEvent},
+		{line: "Sent message to 3\n", kind: NoticeEvent},
+		{line: "Deniable :no sender\n", kind: NoticeEvent},
+	}
+
+	for _, test := range tests {
+		event, err := ParseDenimLine(test.line)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", test.line, err)
+		}
+
+		if event.Kind != test.kind {
+			t.Errorf("%q: got kind %v, want %v", test.line, event.Kind, test.kind)
+			continue
+		}
+
+		if test.kind != MessageEvent {
+			continue
+		}
+
+		msg := event.Msg
+		if msg.From != test.from || msg.ID != test.id || msg.MsgContent != test.content || msg.IsDeniable != test.isDeniable {
+			t.Errorf("%q: got %+v", test.line, *msg)
+		}
+	}
+
+	if _, err := ParseDenimLine("\n"); err != ErrEmptyLine {
+		t.Errorf("Empty line gave %v", err)
+	}
+}
+
+// Seeded with received messages in the client's format carrying the simulator's own bodies, and
+// with transcripts recorded from clients, e.g. a run's clients/<name>.stdout.log copied to testdata/.
+func FuzzParseDenimLine(f *testing.F) {
+	for i := range 8 {
+		id := Messagemaker.NewMessageID()
+		f.Add(fmt.Sprintf("Regular %v:%v%v %v\n", i, Messagemaker.MessageIDTag, id, Messagemaker.GetQuoteByIndexSafe(i)))
+		f.Add(fmt.Sprintf("Deniable %v:%v%v %v\n", i, Messagemaker.AttachmentChunkTag, id, strings.Repeat("abc:", i)))
+	}
+
+	transcripts, err := filepath.Glob("testdata/*.log")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, transcript := range transcripts {
+		file, err := os.Open(transcript)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			f.Add(scanner.Text() + "\n")
+		}
+		file.Close()
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		event, err := ParseDenimLine(line)
+		if err != nil {
+			return
+		}
+
+		if event.Text != strings.TrimRight(line, "\r\n") {
+			t.Errorf("Text %q does not match line %q", event.Text, line)
+		}
+
+		if event.Kind != MessageEvent {
+			if event.Msg != nil {
+				t.Errorf("Non-message event %v carries a message", event.Kind)
+			}
+			return
+		}
+
+		// The body must survive parsing in full
+		msg := event.Msg
+		if !strings.HasSuffix(event.Text, msg.MsgContent) {
+			t.Errorf("Body %q truncated from %q", msg.MsgContent, event.Text)
+		}
+		if msg.From == "" || strings.Contains(msg.From, ":") {
+			t.Errorf("Invalid sender %q from %q", msg.From, event.Text)
+		}
+	})
+}
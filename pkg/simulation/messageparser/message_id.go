@@ -0,0 +1,33 @@
+package Messageparser
+
+import (
+	Messagemaker "deniable-im/im-sim/pkg/simulation/messagemaker"
+	"strings"
+)
+
+// Splits the simulator message ID from the body. Returns an empty ID if the body carries none.
+func ExtractMessageID(body string) (string, string) {
+	trimmed := strings.TrimLeft(body, " ")
+	if !strings.HasPrefix(trimmed, Messagemaker.MessageIDTag) {
+		return "", body
+	}
+
+	id, rest, _ := strings.Cut(trimmed[len(Messagemaker.MessageIDTag):], " ")
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return "", body
+		}
+	}
+	if id == "" {
+		return "", body
+	}
+
+	return id, rest
+}
+
+// Whether a received body is filler of an emulated attachment rather than a message
+func IsAttachmentChunk(body string) bool {
+	id, _, found := strings.Cut(strings.TrimLeft(body, " "), " ")
+	id, tagged := strings.CutPrefix(id, Messagemaker.AttachmentChunkTag)
+	return found && tagged && id != "" && strings.Trim(id, "0123456789abcdef") == ""
+}
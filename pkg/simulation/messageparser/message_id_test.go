@@ -0,0 +1,40 @@
+package Messageparser
+
+import "testing"
+
+func TestExtractMessageID(t *testing.T) {
+	tests := map[string][2]string{
+		"#0000002a hello there": {"0000002a", "hello there"},
+		" #0000002a hello":      {"0000002a", "hello"},
+		"#0000002a":             {"0000002a", ""},
+		"# hello":               {"", "# hello"},
+		"#xyz hello":            {"", "#xyz hello"},
+		"hello #0000002a":       {"", "hello #0000002a"},
+		"+0000002a abcdefgh":    {"", "+0000002a abcdefgh"},
+	}
+
+	for body, want := range tests {
+		id, content := ExtractMessageID(body)
+		if id != want[0] || content != want[1] {
+			t.Errorf("%q: got (%q, %q), want (%q, %q)", body, id, content, want[0], want[1])
+		}
+	}
+}
+
+func TestIsAttachmentChunk(t *testing.T) {
+	tests := map[string]bool{
+		"+0000002a abcdefgh":  true,
+		" +0000002a abcdefgh": true,
+		"+0000002a":           false,
+		"+ abcdefgh":          false,
+		"+zz abcdefgh":        false,
+		"#0000002a caption":   false,
+		"plus one":            false,
+	}
+
+	for body, want := range tests {
+		if got := IsAttachmentChunk(body); got != want {
+			t.Errorf("%q: got %v, want %v", body, got, want)
+		}
+	}
+}
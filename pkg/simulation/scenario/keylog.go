@@ -0,0 +1,63 @@
+package Scenario
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"deniable-im/im-sim/pkg/container"
+)
+
+const (
+	keyLogPath = "/tmp/sslkeys.log" // SSLKEYLOGFILE inside client and server containers
+	KeyLogDir  = "keylog"           // Per-container key logs in the run directory
+	KeyLogFile = "sslkeys.log"      // All key logs of the run, for tshark.Decode
+)
+
+var ErrNoKeyLogs = errors.New("No container exported TLS session keys")
+
+// Environment exporting TLS session keys, only in validation runs
+func (scenario Scenario) keyLogEnv() []string {
+	if !scenario.Validation {
+		return nil
+	}
+	return []string{fmt.Sprintf("SSLKEYLOGFILE=%v", keyLogPath)}
+}
+
+// Copies the key log of each container to keylog/<container>.log and concatenates them into sslkeys.log.
+// Fails with ErrNoKeyLogs if there are no keys at all, since the captures then cannot be decrypted.
+func collectKeyLogs(containers []*container.Container, dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, KeyLogDir), 0750); err != nil {
+		return fmt.Errorf("Failed to create key log directory: %w.", err)
+	}
+
+	combined, err := os.Create(filepath.Join(dir, KeyLogFile))
+	if err != nil {
+		return fmt.Errorf("Failed to create key log: %w.", err)
+	}
+	defer combined.Close()
+
+	var errs []error
+	collected := 0
+	for _, c := range containers {
+		keys, err := c.ReadFile(keyLogPath)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, KeyLogDir, c.Name+".log"), keys, 0640); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := combined.Write(keys); err != nil {
+			errs = append(errs, err)
+		}
+		collected += len(keys)
+	}
+
+	if collected == 0 {
+		errs = append([]error{fmt.Errorf("%w: checked %d containers.", ErrNoKeyLogs, len(containers))}, errs...)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,233 @@
+package Scenario
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/container"
+	Simulator "deniable-im/im-sim/pkg/simulation/simulator"
+	User "deniable-im/im-sim/pkg/simulation/simulator/user"
+)
+
+const ManifestFile = "manifest.json"
+
+// Path of the DenIM commit hash written by the client and server Dockerfiles
+const denimCommitPath = "/denim-commit"
+
+var ErrImageMismatch = errors.New("Image differs from the manifest")
+
+type Seeds struct {
+	Population  int64
+	Contacts    int64
+	UserOptions *int64 `json:",omitempty"` // Overrides Population when set
+}
+
+type ImageInfo struct {
+	Ref         string
+	ID          string
+	RepoDigests []string `json:",omitempty"`
+}
+
+type HostInfo struct {
+	Hostname        string
+	OS              string
+	Arch            string
+	Kernel          string `json:",omitempty"`
+	CPUs            int
+	GoVersion       string
+	DockerVersion   string `json:",omitempty"`
+	SimulatorCommit string `json:",omitempty"`
+}
+
+type UserAddress struct {
+	User      int32
+	Container string
+	IPv4      string
+	IPv6      string `json:",omitempty"`
+}
+
+// Everything needed to reproduce a run
+type Manifest struct {
+	RunID       string
+	Created     time.Time
+	ReplayOf    string `json:",omitempty"`
+	Namespace   int
+	Seeds       Seeds
+	Scenario    Scenario
+	Images      []ImageInfo
+	DenimCommit map[string]string // Commit of the DenIM checkout by role
+	Users       []UserAddress     // Address of every user on the client network
+	Host        HostInfo
+}
+
+// Scenario must already have its seeds resolved. The containers need to exist but not run.
+func NewManifest(dockerClient *client.Client, runID string, namespace int, scenario Scenario, server, client *container.Container) (*Manifest, error) {
+	manifest := &Manifest{
+		RunID:     runID,
+		Created:   time.Now(),
+		Namespace: namespace,
+		Seeds: Seeds{
+			Population: scenario.Seed,
+			Contacts:   scenario.ContactSeed,
+		},
+		Scenario:    scenario,
+		DenimCommit: make(map[string]string),
+		Host:        hostInfo(dockerClient),
+	}
+	if scenario.UserOptions != nil {
+		manifest.Seeds.UserOptions = scenario.UserOptions.Seed
+	}
+
+	images, err := inspectImages(dockerClient, scenario.Images())
+	if err != nil {
+		return nil, err
+	}
+	manifest.Images = images
+
+	for role, c := range map[string]*container.Container{"server": server, "client": client} {
+		commit, err := c.ReadFile(denimCommitPath)
+		if err != nil {
+			fmt.Printf("No DenIM commit for %v: %v\n", role, err)
+			continue
+		}
+		manifest.DenimCommit[role] = strings.TrimSpace(string(commit))
+	}
+
+	return manifest, nil
+}
+
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read manifest: %w.", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("Failed to parse manifest %v: %w.", path, err)
+	}
+
+	return &manifest, nil
+}
+
+func (manifest *Manifest) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("Failed to create %v: %w.", dir, err)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), data, 0644); err != nil {
+		return fmt.Errorf("Failed to write manifest: %w.", err)
+	}
+
+	return nil
+}
+
+// Compares the local images with the ones the manifest was recorded with
+func (manifest *Manifest) CheckImages(dockerClient *client.Client) error {
+	var refs []string
+	for _, image := range manifest.Images {
+		refs = append(refs, image.Ref)
+	}
+
+	current, err := inspectImages(dockerClient, refs)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, image := range manifest.Images {
+		if current[i].ID != image.ID {
+			errs = append(errs, fmt.Errorf("%w: %v is %v, expected %v.", ErrImageMismatch, image.Ref, current[i].ID, image.ID))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Runs the manifest's scenario again with the same seeds in a new namespace
+func (manifest *Manifest) Replay(dockerClient *client.Client) (*Simulator.SimulationResult, error) {
+	return manifest.Scenario.run(dockerClient, manifest.RunID)
+}
+
+func inspectImages(dockerClient *client.Client, refs []string) ([]ImageInfo, error) {
+	images := make([]ImageInfo, len(refs))
+	for i, ref := range refs {
+		inspect, _, err := dockerClient.Cli.ImageInspectWithRaw(dockerClient.Ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to inspect image %v: %w.", ref, err)
+		}
+		images[i] = ImageInfo{Ref: ref, ID: inspect.ID, RepoDigests: inspect.RepoDigests}
+	}
+	return images, nil
+}
+
+// Best effort, fields that cannot be read are left empty
+func hostInfo(dockerClient *client.Client) HostInfo {
+	host := HostInfo{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		GoVersion: runtime.Version(),
+	}
+
+	host.Hostname, _ = os.Hostname()
+
+	if kernel, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		host.Kernel = strings.TrimSpace(string(kernel))
+	}
+
+	if version, err := dockerClient.Cli.ServerVersion(dockerClient.Ctx); err == nil {
+		host.DockerVersion = version.Version
+	}
+
+	host.SimulatorCommit = simulatorCommit()
+	return host
+}
+
+// VCS revision stamped by go build, falling back to git for go run
+func simulatorCommit() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Addresses the users' clients were assigned on networkName
+func UserAddresses(users []*User.SimulatedUser, networkName string) []UserAddress {
+	addresses := make([]UserAddress, len(users))
+	for i, user := range users {
+		addresses[i] = UserAddress{User: user.User.ID, Container: user.Client.Name}
+		if connection, ok := user.Client.Options.Connections[networkName]; ok {
+			if connection.IPv4 != nil {
+				addresses[i].IPv4 = *connection.IPv4
+			}
+			if connection.IPv6 != nil {
+				addresses[i].IPv6 = *connection.IPv6
+			}
+		}
+	}
+	return addresses
+}
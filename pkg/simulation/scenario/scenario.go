@@ -0,0 +1,453 @@
+package Scenario
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	dockerNetwork "github.com/docker/docker/api/types/network"
+
+	"deniable-im/im-sim/internal/types"
+	"deniable-im/im-sim/pkg/client"
+	"deniable-im/im-sim/pkg/container"
+	"deniable-im/im-sim/pkg/image"
+	"deniable-im/im-sim/pkg/labels"
+	"deniable-im/im-sim/pkg/namespace"
+	"deniable-im/im-sim/pkg/network"
+	Behavior "deniable-im/im-sim/pkg/simulation/behavior"
+	"deniable-im/im-sim/pkg/simulation/manager"
+	Simulator "deniable-im/im-sim/pkg/simulation/simulator"
+	User "deniable-im/im-sim/pkg/simulation/simulator/user"
+	Types "deniable-im/im-sim/pkg/simulation/types"
+	"deniable-im/im-sim/pkg/tshark"
+)
+
+// Serialized description of a DenIM simulation run
+type Scenario struct {
+	Name             string
+	BuildCtx         string        // Directory with the Dockerfiles, e.g. "./cmd/denim-sim/"
+	ImagePrefix      string        // Images are tagged <prefix>-client, <prefix>-server and <prefix>-postgres
+	Source           *image.Source `json:",omitempty"` // DenIM revision to build, latest upstream if nil
+	Bundle           string        `json:",omitempty"` // Image bundle the local images must match before a run
+	UserCount        int
+	SimTime          int64                 // Seconds of messaging
+	NetworkProfile   network.Profile       // Driver profile of the client network
+	NetworkParent    string                `json:",omitempty"` // Host interface for macvlan and ipvlan profiles, rejected as it cannot be captured
+	NetworkMTU       int                   `json:",omitempty"` // Driver default if 0
+	IPv6             bool                  // Dual-stack client network
+	MaxMessageDelay  int                   // Upper bound in milliseconds between two messages of a user
+	UserOptions      *Types.SimUserOptions `json:",omitempty"` // Default population if nil
+	RegularContacts  types.Pair[int, int]  // Min and max regular contacts per user
+	DeniableContacts types.Pair[int, int]  // Min and max deniable contacts per user
+	Seed             int64                 // Population seed unless UserOptions.Seed is set, drawn when the run starts if 0
+	ContactSeed      int64                 // Drawn when the run starts if 0
+	StatsInterval    int                   // Seconds between resource samples, no sampling if 0
+	ReadyTimeout     int                   // Seconds each service and client may take to become ready
+	Unattended       bool                  // Start messaging without waiting for enter
+	UserCaptures     bool                  // Also write captures/<user>.pcapng with each user's traffic
+	BackendCapture   bool                  // Capture the backend network into server/backend.pcapng
+	Capture          *tshark.Options       `json:",omitempty"` // Filters, ring buffer and compression of the captures, tshark defaults if nil
+	SnapshotInterval int                   // Seconds between redis and postgres snapshots in server/snapshots, none if 0
+	Validation       bool                  // Export TLS session keys into the run directory so captures can be decrypted. Never for datasets.
+	Teardown         bool                  // Remove containers and networks after the run
+}
+
+// The 100 user, 8 hour simulation of cmd/denim-sim
+func DefaultDenimScenario() Scenario {
+	return Scenario{
+		Name:             "denim",
+		BuildCtx:         "./cmd/denim-sim/",
+		ImagePrefix:      "denim",
+		UserCount:        100,
+		SimTime:          8 * 3600,
+		NetworkProfile:   network.ProfileMacvlan,
+		MaxMessageDelay:  10000,
+		RegularContacts:  types.MakePair(3, 4),
+		DeniableContacts: types.MakePair(1, 2),
+		ContactSeed:      6969420,
+		StatsInterval:    10,
+		ReadyTimeout:     120,
+	}
+}
+
+func Load(path string) (Scenario, error) {
+	scenario := DefaultDenimScenario()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario, fmt.Errorf("Failed to read scenario: %w.", err)
+	}
+
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return scenario, fmt.Errorf("Failed to parse scenario %v: %w.", path, err)
+	}
+
+	return scenario, scenario.Validate()
+}
+
+// Rejects scenarios a run cannot set up
+func (scenario Scenario) Validate() error {
+	if scenario.UserCount <= 0 {
+		return fmt.Errorf("Scenario %v has %d users, at least one is required.", scenario.Name, scenario.UserCount)
+	}
+	// The clients' network is always captured, see network.CaptureInterface
+	if scenario.NetworkParent != "" {
+		return fmt.Errorf("Scenario %v sets NetworkParent %q, the clients' traffic cannot be captured on a parent interface.", scenario.Name, scenario.NetworkParent)
+	}
+	return nil
+}
+
+func (scenario Scenario) image(name string) string {
+	return fmt.Sprintf("%v-%v", scenario.ImagePrefix, name)
+}
+
+// Pulls redis and builds the postgres, server and client images. Unchanged images are kept unless rebuild is set.
+func (scenario Scenario) BuildImages(dockerClient *client.Client, rebuild bool) error {
+	if _, err := image.NewImage(dockerClient, scenario.BuildCtx, &image.Options{
+		PullOpt: &image.PullOptions{
+			RefStr: "redis:latest",
+		},
+	}); err != nil {
+		return err
+	}
+
+	for _, build := range []types.Pair[string, string]{
+		types.MakePair("postgres", labels.RoleDB),
+		types.MakePair("server", labels.RoleServer),
+		types.MakePair("client", labels.RoleClient),
+	} {
+		if _, err := image.NewImage(dockerClient, scenario.BuildCtx, &image.Options{
+			BuildOpt: &dockerTypes.ImageBuildOptions{
+				Dockerfile: fmt.Sprintf("Dockerfile.%v", build.Fst),
+				Tags:       []string{scenario.image(build.Fst)},
+			},
+			Labels:  labels.Image(build.Snd),
+			Source:  scenario.Source,
+			Rebuild: rebuild,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Images used by a run in the order they are started
+func (scenario Scenario) Images() []string {
+	return []string{"redis:latest", scenario.image("postgres"), scenario.image("server"), scenario.image("client")}
+}
+
+// Copy with zero seeds replaced by fresh ones, so the run can be replayed from its manifest
+func (scenario Scenario) WithSeeds() Scenario {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for scenario.Seed == 0 {
+		scenario.Seed = r.Int63()
+	}
+	for scenario.ContactSeed == 0 {
+		scenario.ContactSeed = r.Int63()
+	}
+	return scenario
+}
+
+// Users with behavior and contacts for the client containers. Identical for identical seeds.
+func (scenario Scenario) Population(clientContainers []*container.Container) []*User.SimulatedUser {
+	options := &Types.SimUserOptions{Seed: &scenario.Seed}
+	if scenario.UserOptions != nil {
+		copied := *scenario.UserOptions
+		if copied.Seed == nil {
+			copied.Seed = &scenario.Seed
+		}
+		options = &copied
+	}
+
+	users := manager.MakeSimUsersFromOptions(scenario.UserCount, clientContainers, NextMessageFunc(scenario.MaxMessageDelay), options)
+	r := rand.New(rand.NewSource(scenario.ContactSeed))
+	User.CreateCommunicationNetwork(users, scenario.RegularContacts.Fst, scenario.RegularContacts.Snd, r)
+	User.CreateDeniableNetwork(users, scenario.DeniableContacts.Fst, scenario.DeniableContacts.Snd, r)
+
+	return users
+}
+
+// Checks that the images exist without building them
+func (scenario Scenario) CheckImages(dockerClient *client.Client) error {
+	return image.CheckImages(dockerClient, scenario.Images())
+}
+
+// Namespaces are picked from the networks on the host, so concurrent runs set up one at a time
+var setupMu sync.Mutex
+
+// Runs the scenario in its own namespace. Images must already be built.
+func (scenario Scenario) Run(dockerClient *client.Client) (*Simulator.SimulationResult, error) {
+	return scenario.run(dockerClient, "")
+}
+
+func (scenario Scenario) run(dockerClient *client.Client, replayOf string) (*Simulator.SimulationResult, error) {
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+
+	runID := labels.NewRunID()
+	scenario = scenario.WithSeeds()
+
+	if scenario.Bundle != "" {
+		if _, err := image.VerifyBundle(dockerClient, scenario.Bundle, scenario.Images()); err != nil {
+			return nil, err
+		}
+	}
+
+	setupMu.Lock()
+	ns, err := namespace.New(dockerClient, runID)
+	if err != nil {
+		setupMu.Unlock()
+		return nil, err
+	}
+	ns.IPv6 = scenario.IPv6
+	fmt.Printf("Run %v in namespace %d\n", runID, ns.Index)
+	if scenario.Validation {
+		fmt.Println("Validation run: TLS session keys are exported, do not use its captures as a dataset")
+	}
+
+	// Create network for DB, cache and server
+	networkBackend, err := network.NewNetwork(dockerClient, ns.Name("backend"), network.Options{
+		Profile: network.ProfileBridge,
+		Labels:  ns.Labels(labels.RoleNetwork),
+	})
+	if err != nil {
+		setupMu.Unlock()
+		ns.Claim.Remove(false)
+		return nil, err
+	}
+
+	// Create network for clients and server
+	networkIMvlan, err := network.NewNetwork(dockerClient, ns.Name("IMvlan"), network.Options{
+		Profile:    scenario.NetworkProfile,
+		Parent:     scenario.NetworkParent,
+		MTU:        scenario.NetworkMTU,
+		Labels:     ns.Labels(labels.RoleNetwork),
+		IPAM:       ns.IPAM(),
+		EnableIPv6: ns.IPv6,
+	})
+	setupMu.Unlock()
+	if err != nil {
+		if networkBackend.Created {
+			networkBackend.Remove(false)
+		}
+		ns.Claim.Remove(false)
+		return nil, err
+	}
+
+	// The claim goes last so the index stays reserved until the run's subnets are free
+	var containers []*container.Container
+	networks := []*network.Network{networkBackend, networkIMvlan, ns.Claim}
+	if scenario.Teardown {
+		defer func() {
+			if err := container.Teardown(dockerClient, containers, networks); err != nil {
+				fmt.Println(err)
+			}
+		}()
+	}
+
+	networkName, err := networkIMvlan.CaptureInterface()
+	if err != nil {
+		return nil, err
+	}
+
+	infrastructure := make(map[string]*container.Container)
+	for _, setup := range []struct {
+		role, image, name, alias string
+		network                  *network.Network
+	}{
+		{labels.RoleCache, "redis:latest", scenario.image("redis"), "redis", networkBackend},
+		{labels.RoleDB, scenario.image("postgres"), scenario.image("postgres"), "db", networkBackend},
+	} {
+		c, err := container.NewContainer(dockerClient, setup.image, ns.Name(setup.name), &container.Options{
+			Labels:      ns.Labels(setup.role),
+			Connections: network.NewConnections(setup.network),
+			HostConfig: &dockerContainer.HostConfig{
+				Runtime: "crun",
+			},
+			NetworkConfig: &dockerNetwork.NetworkingConfig{
+				EndpointsConfig: map[string]*dockerNetwork.EndpointSettings{
+					setup.network.Name: {
+						Aliases: []string{setup.alias},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, c)
+		infrastructure[setup.role] = c
+
+		if err := c.Start(); err != nil {
+			return nil, err
+		}
+
+		probe := c.RedisProbe()
+		if setup.role == labels.RoleDB {
+			probe = c.PostgresProbe()
+		}
+		if err := container.WaitFor(dockerClient.Ctx, c.Name, probe, scenario.readyTimeout()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Setup server
+	serverIPs := ns.ServerIPs()
+	server, err := container.NewContainer(dockerClient, scenario.image("server"), ns.Name(scenario.image("server")), &container.Options{
+		Labels: ns.Labels(labels.RoleServer),
+		Env:    scenario.keyLogEnv(),
+		Connections: network.NewConnections(
+			networkBackend,
+			network.NewAddrMapping(networkIMvlan, serverIPs...),
+		),
+		HostConfig: &dockerContainer.HostConfig{
+			Runtime: "crun",
+		},
+		NetworkConfig: &dockerNetwork.NetworkingConfig{
+			EndpointsConfig: map[string]*dockerNetwork.EndpointSettings{
+				networkIMvlan.Name: {
+					Aliases: []string{"server"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	containers = append(containers, server)
+	infrastructure[labels.RoleServer] = server
+
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+
+	// Probed through the backend bridge, the client network is not reachable from the host
+	serverBackendIP, err := server.IPAddress(networkBackend.Name)
+	if err != nil {
+		return nil, err
+	}
+	serverProbe := container.TLSProbe(net.JoinHostPort(serverBackendIP, serverTLSPort), scenario.serverTLSConfig())
+	if err := container.WaitFor(dockerClient.Ctx, server.Name, serverProbe, scenario.readyTimeout()); err != nil {
+		return nil, err
+	}
+
+	// Setup clients
+	var images []types.Pair[string, string]
+	for i := range scenario.UserCount {
+		images = append(images, types.MakePair(scenario.image("client"), ns.Name(fmt.Sprintf("%v-%d", scenario.image("client"), i))))
+	}
+
+	clientContainers, err := container.NewContainerSlice(
+		dockerClient,
+		images,
+		&container.Options{
+			Labels:      ns.Labels(labels.RoleClient),
+			Env:         append(ns.ClientEnv(), scenario.keyLogEnv()...),
+			Connections: network.NewConnections(networkIMvlan),
+			HostConfig: &dockerContainer.HostConfig{
+				Runtime: "crun",
+			},
+		})
+	if err != nil {
+		return nil, err
+	}
+	containers = append(containers, clientContainers...)
+
+	clientContainers, err = container.AssignIP(clientContainers, serverIPs, *networkIMvlan)
+	if err != nil {
+		return nil, err
+	}
+
+	container.StartContainers(clientContainers)
+
+	users := scenario.Population(clientContainers)
+	for _, user := range users {
+		user.ReadyTimeout = scenario.readyTimeout()
+	}
+
+	logDir := fmt.Sprintf("logs/%v", runID)
+	manifest, err := NewManifest(dockerClient, runID, ns.Index, scenario, server, clientContainers[0])
+	if err != nil {
+		return nil, err
+	}
+	manifest.ReplayOf = replayOf
+	manifest.Users = UserAddresses(users, networkIMvlan.Name)
+	if err := manifest.Write(logDir); err != nil {
+		return nil, err
+	}
+
+	backendNetwork := ""
+	if scenario.BackendCapture {
+		backendNetwork, err = networkBackend.CaptureInterface()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	println("Starting simulation")
+	result, err := Simulator.SimulateTraffic(users, scenario.SimTime, networkName, &Simulator.Options{
+		Infrastructure:   infrastructure,
+		StatsInterval:    time.Duration(scenario.StatsInterval) * time.Second,
+		LogDir:           logDir,
+		Unattended:       scenario.Unattended,
+		UserCaptures:     scenario.UserCaptures,
+		BackendNetwork:   backendNetwork,
+		SnapshotInterval: time.Duration(scenario.SnapshotInterval) * time.Second,
+		Capture:          scenario.Capture,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if scenario.Validation {
+		if err := collectKeyLogs(append([]*container.Container{server}, clientContainers...), result.Dir); err != nil {
+			fmt.Println(err)
+			result.Warnings = append(result.Warnings, err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+const serverTLSPort = "443"
+
+func (scenario Scenario) readyTimeout() time.Duration {
+	if scenario.ReadyTimeout <= 0 {
+		return User.DefaultReadyTimeout
+	}
+	return time.Duration(scenario.ReadyTimeout) * time.Second
+}
+
+// Verifies the server certificate if the CA was generated in the build context
+func (scenario Scenario) serverTLSConfig() *tls.Config {
+	config, err := container.LoadTLSConfig(filepath.Join(scenario.BuildCtx, "cert", "rootCA.crt"), "server")
+	if err != nil {
+		return nil
+	}
+	return config
+}
+
+// Uniform delay up to maxDelay milliseconds, shortened by the burst modifier while bursting
+func NextMessageFunc(maxDelay int) func(*Behavior.SimpleHumanTraits) int {
+	return func(sht *Behavior.SimpleHumanTraits) int {
+		var next float64 = float64(maxDelay)
+		if sht.IsBursting() {
+			next = next * sht.BurstModifier
+			sht.DeniableCount -= 1
+
+			return int(sht.GetRandomizer().Int31n((int32(next / 2)) + int32(next/2)))
+		}
+
+		return int(sht.GetRandomizer().Int31n(int32(next)))
+	}
+}
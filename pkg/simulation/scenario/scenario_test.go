@@ -0,0 +1,62 @@
+package Scenario
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"deniable-im/im-sim/pkg/container"
+)
+
+func TestPopulationIsReproducible(t *testing.T) {
+	scenario := DefaultDenimScenario()
+	scenario.UserCount = 10
+	scenario = scenario.WithSeeds()
+
+	population := func() []byte {
+		clients := make([]*container.Container, scenario.UserCount)
+		for i := range clients {
+			clients[i] = &container.Container{}
+		}
+
+		users := scenario.Population(clients)
+		data, err := json.Marshal(users)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	if first, second := population(), population(); string(first) != string(second) {
+		t.Errorf("Same seeds produced different populations")
+	}
+}
+
+func TestLoadRejectsScenarioWithoutUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	if err := os.WriteFile(path, []byte(`{"UserCount": 0}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Error("Expected a scenario without users to be rejected")
+	}
+	if err := DefaultDenimScenario().Validate(); err != nil {
+		t.Errorf("Default scenario rejected: %v", err)
+	}
+
+	parented := DefaultDenimScenario()
+	parented.NetworkProfile, parented.NetworkParent = "macvlan", "auto"
+	if err := parented.Validate(); err == nil {
+		t.Error("Expected a scenario with a parent interface to be rejected")
+	}
+}
+
+func TestCollectKeyLogsWithoutKeys(t *testing.T) {
+	dir := t.TempDir()
+	if err := collectKeyLogs(nil, dir); !errors.Is(err, ErrNoKeyLogs) {
+		t.Errorf("Expected ErrNoKeyLogs, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package simlogger
+
+import (
+	Types "deniable-im/im-sim/pkg/simulation/types"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type DeliveryRecord struct {
+	ID         string
+	From, To   string
+	IsDeniable bool
+	Attachment *Types.Attachment `json:",omitempty"`
+	SentAt     time.Time
+	ReceivedAt []time.Time `json:",omitempty"`
+	LatencyMs  *int64      `json:",omitempty"`
+	Lost       bool
+	Duplicates int
+}
+
+type DeliverySummary struct {
+	Sent          int
+	Delivered     int
+	Lost          int
+	Duplicates    int
+	Unmatched     int // Received messages without a known ID
+	MeanLatencyMs float64
+	P50LatencyMs  int64
+	P95LatencyMs  int64
+	MaxLatencyMs  int64
+}
+
+type DeliveryReport struct {
+	Summary  DeliverySummary
+	Messages []DeliveryRecord
+}
+
+// Matches Send and Receive events on the message ID embedded by the message maker. A Receive
+// can be logged before its Send, e.g. for attachments sent in several lines, so receives of
+// unknown IDs are held until the Send arrives or the report is made.
+type DeliveryTracker struct {
+	mu        sync.Mutex
+	records   map[string]*DeliveryRecord
+	pending   map[string][]time.Time // Receive times of IDs without a Send yet
+	order     []string
+	unmatched int
+}
+
+func NewDeliveryTracker() *DeliveryTracker {
+	return &DeliveryTracker{records: make(map[string]*DeliveryRecord), pending: make(map[string][]time.Time)}
+}
+
+func (dt *DeliveryTracker) Track(event Types.MsgEvent) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	msg := event.Msg
+	switch event.EventType {
+	case "Send":
+		if msg.ID == "" {
+			return
+		}
+		record := &DeliveryRecord{
+			ID:         msg.ID,
+			From:       msg.From,
+			To:         msg.To,
+			IsDeniable: msg.IsDeniable,
+			Attachment: msg.Attachment,
+			SentAt:     event.Timestamp,
+		}
+		dt.records[msg.ID] = record
+		dt.order = append(dt.order, msg.ID)
+
+		for _, receivedAt := range dt.pending[msg.ID] {
+			record.receive(receivedAt)
+		}
+		delete(dt.pending, msg.ID)
+	case "Receive":
+		if msg.ID == "" {
+			dt.unmatched++
+			return
+		}
+		record, ok := dt.records[msg.ID]
+		if !ok {
+			dt.pending[msg.ID] = append(dt.pending[msg.ID], event.Timestamp)
+			return
+		}
+		record.receive(event.Timestamp)
+	}
+}
+
+// The first receive sets the latency, later ones are duplicates
+func (record *DeliveryRecord) receive(receivedAt time.Time) {
+	if len(record.ReceivedAt) == 0 {
+		latency := receivedAt.Sub(record.SentAt).Milliseconds()
+		record.LatencyMs = &latency
+	} else {
+		record.Duplicates++
+	}
+	record.ReceivedAt = append(record.ReceivedAt, receivedAt)
+}
+
+// Messages not received by the time of the report are counted as lost, receives still
+// waiting for their Send as unmatched
+func (dt *DeliveryTracker) Report() DeliveryReport {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	report := DeliveryReport{Messages: make([]DeliveryRecord, 0, len(dt.order))}
+	report.Summary.Unmatched = dt.unmatched
+	for _, receivedAt := range dt.pending {
+		report.Summary.Unmatched += len(receivedAt)
+	}
+
+	var latencies []int64
+	for _, id := range dt.order {
+		record := *dt.records[id]
+		record.Lost = len(record.ReceivedAt) == 0
+
+		report.Summary.Sent++
+		report.Summary.Duplicates += record.Duplicates
+		if record.Lost {
+			report.Summary.Lost++
+		} else {
+			report.Summary.Delivered++
+			latencies = append(latencies, *record.LatencyMs)
+		}
+
+		report.Messages = append(report.Messages, record)
+	}
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		var total int64
+		for _, latency := range latencies {
+			total += latency
+		}
+		report.Summary.MeanLatencyMs = float64(total) / float64(len(latencies))
+		report.Summary.P50LatencyMs = latencies[len(latencies)*50/100]
+		report.Summary.P95LatencyMs = latencies[len(latencies)*95/100]
+		report.Summary.MaxLatencyMs = latencies[len(latencies)-1]
+	}
+
+	return report
+}
+
+// Waits for the event logger to stop so the report covers every tracked event
+func (sl *SimLogger) LogDelivery() (DeliverySummary, error) {
+	<-sl.done
+	report := sl.delivery.Report()
+
+	jsonData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return report.Summary, fmt.Errorf("Failed to marshal delivery report: %w.", err)
+	}
+
+	filename := fmt.Sprintf("%v/delivery.json", sl.Dir)
+	if err := os.WriteFile(filename, jsonData, 0644); err != nil {
+		return report.Summary, fmt.Errorf("Failed to write %v: %w.", filename, err)
+	}
+
+	return report.Summary, nil
+}
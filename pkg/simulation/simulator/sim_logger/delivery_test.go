@@ -0,0 +1,58 @@
+package simlogger
+
+import (
+	Types "deniable-im/im-sim/pkg/simulation/types"
+	"testing"
+	"time"
+)
+
+func TestDeliveryTracker(t *testing.T) {
+	sent := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	event := func(eventType, id string, after time.Duration) Types.MsgEvent {
+		return Types.MsgEvent{EventType: eventType, Msg: Types.Msg{ID: id, From: "1", To: "2"}, Timestamp: sent.Add(after)}
+	}
+
+	tracker := NewDeliveryTracker()
+	for _, e := range []Types.MsgEvent{
+		event("Receive", "a", 100*time.Millisecond),
+		event("Send", "a", 0),
+		event("Send", "b", 0),
+		event("Send", "c", 0),
+		event("Receive", "b", 300*time.Millisecond),
+		event("Receive", "b", 500*time.Millisecond),
+		event("Receive", "", 0),
+		event("Receive", "unknown", 0),
+		event("Restart", "", 0),
+	} {
+		tracker.Track(e)
+	}
+
+	report := tracker.Report()
+	want := DeliverySummary{
+		Sent:          3,
+		Delivered:     2,
+		Lost:          1,
+		Duplicates:    1,
+		Unmatched:     2,
+		MeanLatencyMs: 200,
+		P50LatencyMs:  300,
+		P95LatencyMs:  300,
+		MaxLatencyMs:  300,
+	}
+	if report.Summary != want {
+		t.Errorf("got %+v, want %+v", report.Summary, want)
+	}
+
+	if len(report.Messages) != 3 {
+		t.Fatalf("got %d records, want 3", len(report.Messages))
+	}
+	if a := report.Messages[0]; a.ID != "a" || *a.LatencyMs != 100 {
+		t.Errorf("receive before its send should be matched, got %+v", a)
+	}
+	if b := report.Messages[1]; b.ID != "b" || *b.LatencyMs != 300 || len(b.ReceivedAt) != 2 {
+		t.Errorf("first receive should set the latency, got %+v", b)
+	}
+	if c := report.Messages[2]; !c.Lost || c.LatencyMs != nil {
+		t.Errorf("unreceived message should be lost, got %+v", c)
+	}
+}
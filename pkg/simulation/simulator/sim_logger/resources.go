@@ -0,0 +1,120 @@
+package simlogger
+
+import (
+	Container "deniable-im/im-sim/pkg/container"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const resourcePoolSize = 50
+
+type ResourceTarget struct {
+	Container *Container.Container
+	Role      string // client, server, db or cache
+	User      string // Empty for non-client containers
+}
+
+var resourceHeader = []string{
+	"timestamp", "container", "role", "user",
+	"cpu_percent", "cpu_throttled_ns", "memory_usage", "memory_limit",
+	"pids", "net_rx", "net_tx", "block_read", "block_write",
+}
+
+// Samples every target each interval into resources.csv until stop is closed. The returned channel is closed when the file is written.
+func (sl *SimLogger) LogResources(targets []ResourceTarget, interval time.Duration, stop <-chan bool) <-chan struct{} {
+	done := make(chan struct{})
+
+	filename := fmt.Sprintf("%v/resources.csv", sl.Dir)
+	f, err := os.Create(filename)
+	if err != nil {
+		fmt.Println("Error creating file:", err)
+		close(done)
+		return done
+	}
+
+	writer := csv.NewWriter(f)
+	writer.Write(resourceHeader)
+
+	go func() {
+		defer close(done)
+		defer f.Close()
+		defer writer.Flush()
+
+		previous := make([]*Container.Stats, len(targets))
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			samples := sampleResources(targets)
+			for i, stats := range samples {
+				if stats == nil {
+					continue
+				}
+				writer.Write(resourceRecord(targets[i], stats, previous[i]))
+				previous[i] = stats
+			}
+			writer.Flush()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return done
+}
+
+func sampleResources(targets []ResourceTarget) []*Container.Stats {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, resourcePoolSize)
+
+	samples := make([]*Container.Stats, len(targets))
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, target ResourceTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			stats, err := target.Container.Stats()
+			if err != nil {
+				fmt.Printf("Failed to sample %v: %v\n", target.Container.Name, err)
+				return
+			}
+			samples[i] = stats
+		}(i, target)
+	}
+	wg.Wait()
+
+	return samples
+}
+
+func resourceRecord(target ResourceTarget, stats, previous *Container.Stats) []string {
+	var throttled uint64
+	if previous != nil && stats.Throttled >= previous.Throttled {
+		throttled = stats.Throttled - previous.Throttled
+	}
+
+	return []string{
+		stats.Read.Format(time.RFC3339Nano),
+		target.Container.Name,
+		target.Role,
+		target.User,
+		strconv.FormatFloat(stats.CPUPercent(previous), 'f', 2, 64),
+		strconv.FormatUint(throttled, 10),
+		strconv.FormatUint(stats.MemoryUsage, 10),
+		strconv.FormatUint(stats.MemoryLimit, 10),
+		strconv.FormatUint(stats.Pids, 10),
+		strconv.FormatUint(stats.NetRx, 10),
+		strconv.FormatUint(stats.NetTx, 10),
+		strconv.FormatUint(stats.BlockRead, 10),
+		strconv.FormatUint(stats.BlockWrite, 10),
+	}
+}
@@ -0,0 +1,46 @@
+package simlogger
+
+import (
+	Container "deniable-im/im-sim/pkg/container"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestResourceRecord(t *testing.T) {
+	read := time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC)
+	previous := &Container.Stats{Read: read.Add(-time.Second), CPUTotal: 1000, SystemCPU: 10000, OnlineCPUs: 2, Throttled: 300}
+	stats := &Container.Stats{
+		Read:        read,
+		CPUTotal:    1500,
+		SystemCPU:   20000,
+		OnlineCPUs:  2,
+		Throttled:   800,
+		MemoryUsage: 1048576,
+		MemoryLimit: 8388608,
+		Pids:        7,
+		NetRx:       1010,
+		NetTx:       2020,
+		BlockRead:   4096,
+		BlockWrite:  1024,
+	}
+	target := ResourceTarget{Container: &Container.Container{Name: "client-3"}, Role: "client", User: "3"}
+
+	want := []string{
+		"2024-05-01T12:00:01Z", "client-3", "client", "3",
+		"10.00", "500", "1048576", "8388608",
+		"7", "1010", "2020", "4096", "1024",
+	}
+	if got := resourceRecord(target, stats, previous); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(want) != len(resourceHeader) {
+		t.Errorf("Record has %d columns, header %d", len(want), len(resourceHeader))
+	}
+
+	// The first sample has no CPU usage or throttling to compare against
+	first := resourceRecord(target, stats, nil)
+	if first[4] != "0.00" || first[5] != "0" {
+		t.Errorf("First sample got cpu %v and throttled %v", first[4], first[5])
+	}
+}
@@ -0,0 +1,115 @@
+package simlogger
+
+import (
+	Container "deniable-im/im-sim/pkg/container"
+	"deniable-im/im-sim/pkg/labels"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Subdirectory of the run with what the server operator sees: backend capture, logs and storage snapshots
+const ServerDir = "server"
+
+// Commands dumping the state a storage role holds, run inside its container. Redis is dumped
+// as an RDB file with every key, value and expiry, readable with e.g. rdb-tools or redis-server.
+var snapshotCommands = map[string]struct {
+	commands  []string
+	extension string
+}{
+	labels.RoleCache: {[]string{"sh", "-c", `redis-cli --rdb /tmp/snapshot.rdb >&2 && cat /tmp/snapshot.rdb && rm /tmp/snapshot.rdb`}, "rdb"},
+	labels.RoleDB:    {[]string{"sh", "-c", `pg_dump -U "$POSTGRES_USER" --data-only --inserts "$POSTGRES_DB"`}, "sql"},
+}
+
+// Creates the server directory and returns its path
+func (sl *SimLogger) ServerDir() (string, error) {
+	dir := filepath.Join(sl.Dir, ServerDir)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("Failed to create %v: %w.", dir, err)
+	}
+	return dir, nil
+}
+
+// Writes the stdout and stderr of each infrastructure container to server/<role>.log
+func (sl *SimLogger) LogContainerLogs(infrastructure map[string]*Container.Container) error {
+	if len(infrastructure) == 0 {
+		return nil
+	}
+
+	dir, err := sl.ServerDir()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for role, container := range infrastructure {
+		f, err := os.Create(filepath.Join(dir, role+".log"))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, container.WriteLogs(f), f.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Dumps redis and postgres to server/snapshots/<role>-<unix time> each interval until stop is closed.
+// The returned channel is closed after the final snapshot.
+func (sl *SimLogger) LogSnapshots(infrastructure map[string]*Container.Container, interval time.Duration, stop <-chan bool) <-chan struct{} {
+	done := make(chan struct{})
+
+	dir, err := sl.ServerDir()
+	if err == nil {
+		dir = filepath.Join(dir, "snapshots")
+		err = os.MkdirAll(dir, 0750)
+	}
+	if err != nil {
+		fmt.Println("Error creating snapshot directory:", err)
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				snapshot(infrastructure, dir)
+				return
+			case <-ticker.C:
+				snapshot(infrastructure, dir)
+			}
+		}
+	}()
+
+	return done
+}
+
+func snapshot(infrastructure map[string]*Container.Container, dir string) {
+	now := time.Now().Unix()
+	for role, snapshotCommand := range snapshotCommands {
+		container, ok := infrastructure[role]
+		if !ok {
+			continue
+		}
+
+		filename := filepath.Join(dir, fmt.Sprintf("%v-%d.%v", role, now, snapshotCommand.extension))
+		f, err := os.Create(filename)
+		if err != nil {
+			fmt.Println("Error creating file:", err)
+			continue
+		}
+
+		if err := container.ExecTo(snapshotCommand.commands, f); err != nil {
+			fmt.Println("Snapshot failed:", err)
+		}
+		f.Close()
+	}
+}
@@ -13,36 +13,49 @@ import (
 type SimLogger struct {
 	Dir      string
 	killChan chan bool
+	done     chan struct{} // Closed once messages.json is complete
+	delivery *DeliveryTracker
 }
 
 type UserInfo struct {
 	User          Types.SimUser
 	Behavior      Behavior.Behavior
 	UserIP        string
+	UserIPv6      string `json:",omitempty"`
 	ContainerName string
 }
 
+// Logs to Dir if set, otherwise to a timestamped directory in logs/
 func (sl *SimLogger) InitLogging(kill chan bool) (chan Types.MsgEvent, error) {
 	sl.killChan = kill
 
-	ts := time.Now().String()
-	ts = strings.ReplaceAll(ts, " ", "")
-	ts = strings.ReplaceAll(ts, ":", "")
-	ts = ts[:16]
-	dirname := fmt.Sprintf("logs/%v", ts)
+	dirname := sl.Dir
+	if dirname == "" {
+		ts := time.Now().String()
+		ts = strings.ReplaceAll(ts, " ", "")
+		ts = strings.ReplaceAll(ts, ":", "")
+		ts = ts[:16]
+		dirname = fmt.Sprintf("logs/%v", ts)
+	}
+
 	err := os.MkdirAll(dirname, 0750)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create logs/%v", ts)
+		return nil, fmt.Errorf("Failed to create %v", dirname)
 	}
 
 	sl.Dir = dirname
+	sl.delivery = NewDeliveryTracker()
+	sl.done = make(chan struct{})
 
 	msgLogChan := make(chan Types.MsgEvent)
 	go sl.LogMsgEvent(msgLogChan)
 	return msgLogChan, nil
 }
 
+// Logs events until the kill channel is closed, then closes the JSON array
 func (sl *SimLogger) LogMsgEvent(eventChan chan Types.MsgEvent) {
+	defer close(sl.done)
+
 	path := fmt.Sprintf("%v/messages.json", sl.Dir)
 	f, ferr := os.Create(path)
 	if ferr != nil {
@@ -63,13 +76,16 @@ func (sl *SimLogger) LogMsgEvent(eventChan chan Types.MsgEvent) {
 		select {
 		case <-sl.killChan:
 			return
-		default:
-			logEvent := <-eventChan
-			logEvent.Timestamp = time.Now()
+		case logEvent := <-eventChan:
+			// Receive events carry the time the client output arrived
+			if logEvent.Timestamp.IsZero() {
+				logEvent.Timestamp = time.Now()
+			}
+			sl.delivery.Track(logEvent)
 			jsonData, err := json.MarshalIndent(logEvent, "", " ")
 			if err != nil {
 				fmt.Println("Error marshalling JSON", err)
-				return
+				continue
 			}
 			if !first {
 				_, werr := f.Write([]byte(","))
@@ -86,10 +102,8 @@ func (sl *SimLogger) LogMsgEvent(eventChan chan Types.MsgEvent) {
 				fmt.Println("Error writing msg event to file", werr)
 				return
 			}
-
 		}
 	}
-
 }
 
 func (sl *SimLogger) LogSimUsers(users []UserInfo) {
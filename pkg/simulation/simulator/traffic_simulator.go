@@ -1,80 +1,267 @@
 package Simulator
 
 import (
+	Container "deniable-im/im-sim/pkg/container"
 	SimLogger "deniable-im/im-sim/pkg/simulation/simulator/sim_logger"
 	SimulatedUser "deniable-im/im-sim/pkg/simulation/simulator/user"
+	"errors"
 	"fmt"
+	"maps"
+	"path/filepath"
 	"runtime"
+	"slices"
 
 	"deniable-im/im-sim/pkg/tshark"
-	"sync"
 	"time"
 )
 
-// Should probably return some kind of state, idk
-func SimulateTraffic(users []*SimulatedUser.SimulatedUser, simTime int64, networkInterface string) {
-	var wg sync.WaitGroup
+type Options struct {
+	Infrastructure   map[string]*Container.Container // Non-client containers by role, e.g. "server", "db" and "cache"
+	StatsInterval    time.Duration                   // Resource sampling interval, no resources.csv if 0
+	LogDir           string                          // Run directory, a timestamped directory in logs/ if empty
+	Unattended       bool                            // Start messaging without waiting for enter
+	UserCaptures     bool                            // Split the capture into captures/<user>.pcapng after the run
+	BackendNetwork   string                          // Interface of the server's backend network, captured into server/backend.pcapng if set
+	SnapshotInterval time.Duration                   // Redis and postgres snapshot interval, no snapshots if 0
+	Capture          *tshark.Options                 // Filters, ring buffer and compression of the captures, tshark defaults if nil
+	DrainPeriod      time.Duration                   // Time clients keep receiving after they stop sending, DefaultDrainPeriod if 0
+}
+
+// Long enough for messages sent just before the end of the run to be delivered
+const DefaultDrainPeriod = 10 * time.Second
+
+type SimulationResult struct {
+	Dir      string
+	Users    int
+	SimTime  int64
+	Started  time.Time
+	Finished time.Time
+	Delivery SimLogger.DeliverySummary
+	Capture  []string `json:",omitempty"` // Capture files of the client network
+	Warnings []string `json:",omitempty"` // Problems that did not stop the run, e.g. tshark exiting early
+}
+
+func SimulateTraffic(users []*SimulatedUser.SimulatedUser, simTime int64, networkInterface string, options *Options) (*SimulationResult, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
 	poolSize := 50
 
 	startChan := make(chan struct{})
+	drainChan := make(chan struct{})
 	stopChan := make(chan bool)
 
-	var logger SimLogger.SimLogger
+	logger := SimLogger.SimLogger{Dir: options.LogDir}
 	msgChan, err := logger.InitLogging(stopChan)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	users_to_log := make([]SimLogger.UserInfo, len(users))
 	for i, user := range users {
+		user.LogDir = logger.Dir
 		users_to_log[i].User = (*user.User)
 		users_to_log[i].Behavior = user.Behavior
 		users_to_log[i].ContainerName = user.Client.Name
 		for _, ip := range (*user).Client.Options.Connections {
-			users_to_log[i].UserIP = *ip.IPv4
+			if ip.IPv4 != nil {
+				users_to_log[i].UserIP = *ip.IPv4
+			}
+			if ip.IPv6 != nil {
+				users_to_log[i].UserIPv6 = *ip.IPv6
+			}
 			break
 		}
 	}
 	logger.LogSimUsers(users_to_log)
 
 	println("Initializing clients")
-	time.Sleep(10 * time.Second)
 
-	for i, user := range users {
-		wg.Add(1)
-		go func(user *SimulatedUser.SimulatedUser) {
-			defer wg.Done()
-			go user.StartMessaging(startChan, stopChan, msgChan)
-		}(user)
-		if (i+1)%poolSize == 0 {
-			wg.Wait()
-			time.Sleep(10 * time.Second)
+	// Clients register in pools so the server is not flooded, each pool waits for the previous to be ready
+	for pool := range slices.Chunk(users, poolSize) {
+		ready := make(chan error, len(pool))
+		for _, user := range pool {
+			go user.StartMessaging(ready, startChan, drainChan, stopChan, msgChan)
+		}
+
+		var errs []error
+		for range pool {
+			if err := <-ready; err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			close(stopChan)
+			return nil, fmt.Errorf("Clients failed to start: %w", errors.Join(errs...))
 		}
 	}
-	wg.Wait()
-	time.Sleep(10 * time.Second)
 
-	fmt.Printf("Press enter to begin client messaging on %d threads\n", runtime.NumCPU())
-	fmt.Scanln()
+	if !options.Unattended {
+		fmt.Printf("Press enter to begin client messaging on %d threads\n", runtime.NumCPU())
+		fmt.Scanln()
+	}
 
 	println("Starting Tshark")
-	cmd, cerr := tshark.RunTshark(networkInterface, logger.Dir, simTime+3)
-	if cerr != nil {
-		return
+	capture, err := tshark.Start(networkInterface, filepath.Join(logger.Dir, "capture.pcapng"), options.Capture)
+	if err != nil {
+		close(stopChan)
+		return nil, err
+	}
+	captures := []*tshark.Capture{capture}
+
+	if options.BackendNetwork != "" {
+		// The client filters do not apply to the backend network
+		backendOptions := tshark.Options{}
+		if options.Capture != nil {
+			backendOptions = *options.Capture
+			backendOptions.CaptureFilter, backendOptions.DisplayFilter = "", ""
+		}
+
+		serverDir, err := logger.ServerDir()
+		if err == nil {
+			var backend *tshark.Capture
+			backend, err = tshark.Start(options.BackendNetwork, filepath.Join(serverDir, "backend.pcapng"), &backendOptions)
+			captures = append(captures, backend)
+		}
+		if err != nil {
+			capture.Stop()
+			close(stopChan)
+			return nil, fmt.Errorf("Failed to start backend capture: %w.", err)
+		}
 	}
-	defer cmd.Wait()
+
+	// tshark exits within a second on a bad interface or filter
 	time.Sleep(1 * time.Second)
+	for _, c := range captures {
+		if err := c.Err(); err != nil {
+			stopCaptures(captures)
+			close(stopChan)
+			return nil, err
+		}
+	}
+
+	var resourcesDone <-chan struct{}
+	if options.StatsInterval > 0 {
+		resourcesDone = logger.LogResources(resourceTargets(users, options.Infrastructure), options.StatsInterval, stopChan)
+	}
+
+	var snapshotsDone <-chan struct{}
+	if options.SnapshotInterval > 0 {
+		snapshotsDone = logger.LogSnapshots(options.Infrastructure, options.SnapshotInterval, stopChan)
+	}
 
 	// Clients now start messaging
+	result := &SimulationResult{Dir: logger.Dir, Users: len(users), SimTime: simTime, Started: time.Now()}
 	close(startChan)
 
-	// Duration of simulation
-	time.Sleep(time.Duration((simTime * int64(time.Second))))
+	// Duration of simulation, reporting captures that end early
+	exited := make(chan *tshark.Capture, len(captures))
+	for _, c := range captures {
+		go func() {
+			<-c.Done()
+			exited <- c
+		}()
+	}
+
+	simTimer := time.NewTimer(time.Duration(simTime * int64(time.Second)))
+	for waiting := true; waiting; {
+		select {
+		case <-simTimer.C:
+			waiting = false
+		case c := <-exited:
+			// Recorded in the warnings when the capture is stopped
+			fmt.Printf("Warning: %v\n", c.Err())
+		}
+	}
+
+	// Clients stop sending and receive what is still in flight before they are stopped
+	drainPeriod := options.DrainPeriod
+	if drainPeriod == 0 {
+		drainPeriod = DefaultDrainPeriod
+	}
+	close(drainChan)
+	time.Sleep(drainPeriod)
 
 	// Stop all clients
-	stopChan <- true
+	close(stopChan)
 
 	time.Sleep(time.Duration(5 * time.Second))
+	if resourcesDone != nil {
+		<-resourcesDone
+	}
+
+	if snapshotsDone != nil {
+		<-snapshotsDone
+	}
+
+	println("Stopping Tshark")
+	for _, err := range stopCaptures(captures) {
+		result.Warnings = append(result.Warnings, err.Error())
+	}
+	result.Capture, _ = capture.Files()
+
+	if err := logger.LogContainerLogs(options.Infrastructure); err != nil {
+		fmt.Println(err)
+	}
+
+	if options.UserCaptures {
+		if err := splitUserCaptures(users_to_log, logger.Dir, result.Capture); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	summary, err := logger.LogDelivery()
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Printf("Delivered %d/%d messages, %d lost, %d duplicates, mean latency %.0f ms\n",
+			summary.Delivered, summary.Sent, summary.Lost, summary.Duplicates, summary.MeanLatencyMs)
+	}
+	result.Delivery = summary
+	result.Finished = time.Now()
 
 	println("Simulation is done")
+	return result, nil
+}
+
+// Packets each user's client sent or received, as seen by an observer on its access link
+func splitUserCaptures(users []SimLogger.UserInfo, dir string, captures []string) error {
+	hosts := make(map[string][]string, len(users))
+	for _, user := range users {
+		hosts[fmt.Sprintf("%v", user.User.ID)] = []string{user.UserIP, user.UserIPv6}
+	}
+
+	println("Splitting capture per user")
+	return tshark.SplitCapture(captures, filepath.Join(dir, tshark.CapturesDir), hosts)
+}
+
+// Stops the captures, returning the errors of those that failed or exited early
+func stopCaptures(captures []*tshark.Capture) []error {
+	var errs []error
+	for _, capture := range captures {
+		if err := capture.Stop(); err != nil {
+			fmt.Println(err)
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func resourceTargets(users []*SimulatedUser.SimulatedUser, infrastructure map[string]*Container.Container) []SimLogger.ResourceTarget {
+	// Sorted so rows are in the same order every run
+	var targets []SimLogger.ResourceTarget
+	for _, role := range slices.Sorted(maps.Keys(infrastructure)) {
+		targets = append(targets, SimLogger.ResourceTarget{Container: infrastructure[role], Role: role})
+	}
+
+	for _, user := range users {
+		targets = append(targets, SimLogger.ResourceTarget{
+			Container: user.Client,
+			Role:      "client",
+			User:      fmt.Sprintf("%v", user.User.ID),
+		})
+	}
+
+	return targets
 }
@@ -4,46 +4,118 @@ import (
 	Container "deniable-im/im-sim/pkg/container"
 	Process "deniable-im/im-sim/pkg/process"
 	Behavior "deniable-im/im-sim/pkg/simulation/behavior"
+	Messagemaker "deniable-im/im-sim/pkg/simulation/messagemaker"
+	Messageparser "deniable-im/im-sim/pkg/simulation/messageparser"
 	Types "deniable-im/im-sim/pkg/simulation/types"
+	"errors"
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
-const readTimeout = 0.2
+const processExitTimeout = 5 * time.Second
+
+// Time for a client to register and print its first line
+const DefaultReadyTimeout = 60 * time.Second
+
+// Clients that only print received messages after a "read" command are polled with
+// exponential backoff from MinInterval to MaxInterval, reset whenever output arrives.
+// MaxInterval bounds the delivery latency added by polling an idle client.
+type PollingOptions struct {
+	Disabled    bool // Client pushes output on its own
+	MinInterval time.Duration
+	MaxInterval time.Duration
+}
+
+func DefaultPollingOptions() PollingOptions {
+	return PollingOptions{MinInterval: 50 * time.Millisecond, MaxInterval: 200 * time.Millisecond}
+}
 
 type SimulatedUser struct {
-	Behavior Behavior.Behavior
-	Client   *Container.Container
-	User     *Types.SimUser
-	stopChan chan bool
-	logger   chan Types.MsgEvent
-	Process  *Process.Process
+	Behavior     Behavior.Behavior
+	Client       *Container.Container
+	User         *Types.SimUser
+	stopChan     chan bool
+	drainChan    <-chan struct{}
+	logger       chan Types.MsgEvent
+	Process      *Process.Process
+	LogDir       string                 // Run directory for per-client logs, no logs if empty
+	Restart      *Process.RestartPolicy // Restart policy of the client process, default if nil
+	Polling      *PollingOptions        // Uses DefaultPollingOptions if nil
+	ReadyTimeout time.Duration          // DefaultReadyTimeout if 0
+	pending      []Process.Line         // Read while waiting for readiness, not yet received
 }
 
-func (su *SimulatedUser) StartMessaging(start chan struct{}, stop chan bool, logger chan Types.MsgEvent) {
+// Starts the client and reports on ready once it printed its first line, or why it did not.
+// Messaging begins when start is closed. Once drain is closed the user stops sending but keeps
+// receiving until stop is closed, so messages in flight are not counted as lost.
+func (su *SimulatedUser) StartMessaging(ready chan<- error, start, drain <-chan struct{}, stop chan bool, logger chan Types.MsgEvent) {
 	var wg sync.WaitGroup
 
+	// The caller waits for one answer per user
 	if su == nil {
+		ready <- errors.New("SimulatedUser StartMessaging called on a nil user.")
 		return
 	}
 
 	su.stopChan = stop
+	su.drainChan = drain
 	su.logger = logger
 
 	args := []string{"./client", su.User.Nickname, fmt.Sprintf("%v", su.User.ID), "false"}
 
-	res, err := su.Client.Exec(args, true)
+	execOptions := &Container.ExecOptions{LogOutput: true, Restart: su.Restart, OnExit: su.onProcessExit}
+	if su.LogDir != "" {
+		stdoutLog, err := su.createLog("stdout")
+		if err != nil {
+			ready <- fmt.Errorf("SimulatedUser StartMessaging failed to create stdout log: %w.", err)
+			return
+		}
+		defer stdoutLog.Close()
+		execOptions.Stdout = stdoutLog
+
+		stderrLog, err := su.createLog("stderr")
+		if err != nil {
+			ready <- fmt.Errorf("SimulatedUser StartMessaging failed to create stderr log: %w.", err)
+			return
+		}
+		defer stderrLog.Close()
+		execOptions.Stderr = stderrLog
+	}
+
+	res, err := su.Client.ExecWithOptions(args, execOptions)
 	if err != nil {
-		panic(fmt.Errorf("SimulatedUser StartMessaging failed to start process: %w.", err))
+		ready <- fmt.Errorf("SimulatedUser StartMessaging failed to start process: %w.", err)
+		return
 	}
 
 	su.Process = res
 	defer su.Process.Close()
 
+	timeout := su.ReadyTimeout
+	if timeout == 0 {
+		timeout = DefaultReadyTimeout
+	}
+	first, err := Container.WaitForLine(su.Process.Lines(), timeout)
+	if err == nil {
+		err = su.registered(first)
+	}
+	if err != nil {
+		ready <- fmt.Errorf("Client %v: %w", su.Client.Name, err)
+		return
+	}
+	ready <- nil
+
 	// Await other clients
-	<-start
+	select {
+	case <-start:
+	case <-su.stopChan:
+		su.Process.Shutdown([]byte("quit\n"))
+		return
+	}
 
 	wg.Add(1)
 	go func() {
@@ -51,25 +123,99 @@ func (su *SimulatedUser) StartMessaging(start chan struct{}, stop chan bool, log
 		su.MessageListener()
 	}()
 
-	for {
+	for sending := true; sending; {
+		time_to_next_message := su.Behavior.GetNextMessageTime()
+		dur := time.Duration(time_to_next_message * int(time.Millisecond))
 		select {
+		case <-su.drainChan:
+			sending = false
 		case <-su.stopChan:
-			wg.Wait()
-			err := su.Process.Cmd([]byte("quit\n"))
-			if err != nil {
-				panic(fmt.Errorf("Sim done but failed to send quit: %w.", err))
-			}
-			return
-		default:
-			time_to_next_message := su.Behavior.GetNextMessageTime()
-			dur := time.Duration(time_to_next_message * int(time.Millisecond))
-			time.Sleep(dur)
+			sending = false
+		case <-time.After(dur):
 			msgs := su.Behavior.MakeMessages()
 			for _, msg := range msgs {
 				su.SendMessage(msg)
 			}
 		}
 	}
+
+	<-su.stopChan
+	wg.Wait()
+	err = su.Process.Shutdown([]byte("quit\n"))
+	if err != nil {
+		panic(fmt.Errorf("Sim done but failed to send quit: %w.", err))
+	}
+
+	select {
+	case <-su.Process.Done():
+	case <-time.After(processExitTimeout):
+	}
+}
+
+// Checks the client's first line confirms its registration rather than an error. A message
+// also shows the client is up and is kept for MessageListener.
+func (su *SimulatedUser) registered(line Process.Line) error {
+	event, err := Messageparser.ParseDenimLine(line.Text)
+	if err != nil {
+		return fmt.Errorf("%w: unrecognised first line %q.", Container.ErrNotReady, line.Text)
+	}
+
+	switch event.Kind {
+	case Messageparser.ErrorEvent:
+		return fmt.Errorf("%w: client reported %q.", Container.ErrNotReady, event.Text)
+	case Messageparser.MessageEvent:
+		su.pending = append(su.pending, line)
+	}
+	return nil
+}
+
+// Whether the simulation stopped sending new messages
+func (su *SimulatedUser) draining() bool {
+	select {
+	case <-su.drainChan:
+		return true
+	case <-su.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Logs exits and restarts of the client process. Dropped if the logger has stopped.
+func (su *SimulatedUser) onProcessExit(event Process.Event) {
+	eventType := "Exit"
+	if event.Restarting {
+		eventType = "Restart"
+	}
+
+	processEvent := &Types.ProcessEvent{
+		Container: su.Client.Name,
+		Pid:       event.Status.Pid,
+		ExitCode:  event.Status.ExitCode,
+		Restarts:  event.Restarts,
+	}
+	if event.Err != nil {
+		processEvent.Error = event.Err.Error()
+	}
+
+	select {
+	case su.logger <- Types.MsgEvent{
+		EventType: eventType,
+		Msg:       Types.Msg{From: fmt.Sprintf("%v", su.User.ID)},
+		Process:   processEvent,
+	}:
+	case <-time.After(processExitTimeout):
+	}
+}
+
+// Transcript of one output stream of the client, e.g. clients/<name>.stdout.log
+func (su *SimulatedUser) createLog(stream string) (*os.File, error) {
+	dir := filepath.Join(su.LogDir, "clients")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	return os.Create(filepath.Join(dir, fmt.Sprintf("%v.%v.log", su.Client.Name, stream)))
 }
 
 func (su *SimulatedUser) SendMessage(msg Types.Msg) {
@@ -77,12 +223,28 @@ func (su *SimulatedUser) SendMessage(msg Types.Msg) {
 		return
 	}
 
-	err := su.Process.Cmd([]byte(fmt.Sprintf("%v\n", msg.MsgContent)))
-	if err != nil {
-		panic(fmt.Errorf("SimulatedUser SendMessage failed: %w.", err))
+	lines := []string{msg.MsgContent}
+	if msg.Attachment != nil && msg.Attachment.Emulated {
+		lines = Messagemaker.EmulateAttachment(msg)
+	}
+
+	// Logged first, the recipient can print the first line before the rest is written
+	su.log(Types.MsgEvent{Msg: msg, EventType: "Send", Timestamp: time.Now()})
+
+	for _, line := range lines {
+		err := su.Process.Cmd([]byte(fmt.Sprintf("%v\n", line)))
+		if err != nil {
+			panic(fmt.Errorf("SimulatedUser SendMessage failed: %w.", err))
+		}
 	}
+}
 
-	su.logger <- Types.MsgEvent{Msg: msg, EventType: "Send"}
+// Dropped once the simulation has stopped, as the logger no longer receives
+func (su *SimulatedUser) log(event Types.MsgEvent) {
+	select {
+	case su.logger <- event:
+	case <-su.stopChan:
+	}
 }
 
 func (su *SimulatedUser) OnReceive(msg Types.Msg) {
@@ -99,38 +261,82 @@ func (su *SimulatedUser) OnReceive(msg Types.Msg) {
 	sleep_time := su.Behavior.GetResponseTime()
 	time.Sleep(time.Duration(sleep_time * int(time.Millisecond)))
 
+	// Replies during the drain would be cut off and counted as lost
+	if su.draining() {
+		return
+	}
 	su.SendMessage(res)
 }
 
 func (su *SimulatedUser) MessageListener() {
+	lines := su.Process.Lines()
+
+	activity := make(chan struct{}, 1)
+	polling := DefaultPollingOptions()
+	if su.Polling != nil {
+		polling = *su.Polling
+	}
+	if !polling.Disabled {
+		go su.poll(polling, activity)
+	}
+
+	for _, line := range su.pending {
+		su.receive(line)
+	}
+	su.pending = nil
+
 	for {
 		select {
 		case <-su.stopChan:
 			return
-		default:
-			time.Sleep(time.Duration(readTimeout * float64(time.Second)))
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
 
-			err := su.Process.Cmd([]byte("read\n"))
-			if err != nil {
-				panic(fmt.Errorf("SimulatedUser MessageListener failed: %w.", err))
+			select {
+			case activity <- struct{}{}:
+			default:
 			}
 
-			lines := su.Process.Read(byte('\n'))
-			for _, line := range lines {
-				msg, err := su.Behavior.ParseIncoming(line)
-				if err != nil {
-					continue
-				}
+			su.receive(line)
+		}
+	}
+}
 
-				msg.To = fmt.Sprintf("%v", su.User.ID)
+func (su *SimulatedUser) receive(line Process.Line) {
+	msg, err := su.Behavior.ParseIncoming(line.Text)
+	if err != nil || Messageparser.IsAttachmentChunk(msg.MsgContent) {
+		return
+	}
 
-				su.logger <- Types.MsgEvent{
-					Msg:       *msg,
-					EventType: "Receive",
-				}
+	msg.To = fmt.Sprintf("%v", su.User.ID)
 
-				su.OnReceive(*msg)
+	su.log(Types.MsgEvent{
+		Msg:       *msg,
+		EventType: "Receive",
+		Timestamp: line.Arrived,
+	})
+
+	su.OnReceive(*msg)
+}
+
+// Sends "read" with adaptive backoff, output is picked up by MessageListener as it arrives
+func (su *SimulatedUser) poll(polling PollingOptions, activity <-chan struct{}) {
+	interval := polling.MinInterval
+	for {
+		select {
+		case <-su.stopChan:
+			return
+		case <-activity:
+			interval = polling.MinInterval
+		case <-time.After(interval):
+			err := su.Process.Cmd([]byte("read\n"))
+			if err != nil {
+				panic(fmt.Errorf("SimulatedUser poll failed: %w.", err))
 			}
+
+			interval = min(interval*2, polling.MaxInterval)
 		}
 	}
 }
@@ -1,6 +1,9 @@
 package User
 
 import (
+	Container "deniable-im/im-sim/pkg/container"
+	Process "deniable-im/im-sim/pkg/process"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -41,3 +44,46 @@ func TestCreateCommunicationNetwork(t *testing.T) {
 		}
 	}
 }
+
+func TestStartMessagingNilUser(t *testing.T) {
+	var su *SimulatedUser
+	ready := make(chan error, 1)
+	su.StartMessaging(ready, nil, nil, nil, nil)
+
+	select {
+	case err := <-ready:
+		if err == nil {
+			t.Error("Expected an error for a nil user")
+		}
+	default:
+		t.Error("Nil user did not report on ready")
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	tests := []struct {
+		text    string
+		ready   bool
+		pending int
+	}{
+		{text: "Registered", ready: true},
+		{text: "Regular 2:#0000002a hello", ready: true, pending: 1},
+		{text: "Connection refused (os error 111)"},
+		{text: "thread 'main' panicked at src/main.rs:10:5:"},
+		{text: " "},
+	}
+
+	for _, test := range tests {
+		su := &SimulatedUser{}
+		err := su.registered(Process.Line{Text: test.text})
+		if test.ready != (err == nil) {
+			t.Errorf("%q: got %v", test.text, err)
+		}
+		if err != nil && !errors.Is(err, Container.ErrNotReady) {
+			t.Errorf("%q: expected ErrNotReady, got %v", test.text, err)
+		}
+		if len(su.pending) != test.pending {
+			t.Errorf("%q: %d lines kept for the listener, want %d", test.text, len(su.pending), test.pending)
+		}
+	}
+}
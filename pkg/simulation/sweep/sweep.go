@@ -0,0 +1,283 @@
+package Sweep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"deniable-im/im-sim/pkg/client"
+	Scenario "deniable-im/im-sim/pkg/simulation/scenario"
+	Simulator "deniable-im/im-sim/pkg/simulation/simulator"
+)
+
+var ErrNoValues = errors.New("Parameter has no values to sweep")
+
+// Scenario field to vary, addressed by a dotted path such as "UserOptions.BurstSize"
+type Parameter struct {
+	Name    string
+	Values  []json.RawMessage `json:",omitempty"` // Grid values, or choices for random search
+	Min     *float64          `json:",omitempty"` // Uniform range for random search
+	Max     *float64          `json:",omitempty"`
+	Integer bool              `json:",omitempty"` // Round values drawn from Min and Max
+}
+
+type Sweep struct {
+	Base       Scenario.Scenario
+	Parameters []Parameter
+	Seeds      []int64 // Every combination runs once per seed, with the base seeds if empty
+	Random     int     // Number of random combinations, grid search if 0
+	RandomSeed int64
+	Parallel   int    // Concurrent runs, each in its own namespace
+	Index      string // Index file, logs/sweep-<time>.json if empty
+	NoBuild    bool   // Use the local images, failing if any are missing
+}
+
+type Run struct {
+	Number     int
+	Parameters map[string]json.RawMessage
+	Seed       *int64 `json:",omitempty"`
+	Dir        string
+	Result     *Simulator.SimulationResult `json:",omitempty"`
+	Error      string                      `json:",omitempty"`
+}
+
+type Index struct {
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+	Base     Scenario.Scenario
+	Runs     []Run
+}
+
+func Load(path string) (Sweep, error) {
+	sweep := Sweep{Base: Scenario.DefaultDenimScenario()}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sweep, fmt.Errorf("Failed to read sweep: %w.", err)
+	}
+
+	if err := json.Unmarshal(data, &sweep); err != nil {
+		return sweep, fmt.Errorf("Failed to parse sweep %v: %w.", path, err)
+	}
+
+	return sweep, nil
+}
+
+// Parameter combinations, either the full grid or Random draws
+func (sweep Sweep) Combinations() ([]map[string]json.RawMessage, error) {
+	if sweep.Random > 0 {
+		return sweep.randomCombinations()
+	}
+
+	combinations := []map[string]json.RawMessage{{}}
+	for _, parameter := range sweep.Parameters {
+		if len(parameter.Values) == 0 {
+			return nil, fmt.Errorf("%w: %v.", ErrNoValues, parameter.Name)
+		}
+
+		var next []map[string]json.RawMessage
+		for _, combination := range combinations {
+			for _, value := range parameter.Values {
+				extended := make(map[string]json.RawMessage, len(combination)+1)
+				for name, v := range combination {
+					extended[name] = v
+				}
+				extended[parameter.Name] = value
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+
+	return combinations, nil
+}
+
+func (sweep Sweep) randomCombinations() ([]map[string]json.RawMessage, error) {
+	r := rand.New(rand.NewSource(sweep.RandomSeed))
+
+	combinations := make([]map[string]json.RawMessage, sweep.Random)
+	for i := range combinations {
+		combinations[i] = make(map[string]json.RawMessage, len(sweep.Parameters))
+		for _, parameter := range sweep.Parameters {
+			switch {
+			case len(parameter.Values) > 0:
+				combinations[i][parameter.Name] = parameter.Values[r.Intn(len(parameter.Values))]
+			case parameter.Min != nil && parameter.Max != nil:
+				value := *parameter.Min + r.Float64()*(*parameter.Max-*parameter.Min)
+				if parameter.Integer {
+					value = math.Round(value)
+				}
+				encoded, err := json.Marshal(value)
+				if err != nil {
+					return nil, err
+				}
+				combinations[i][parameter.Name] = encoded
+			default:
+				return nil, fmt.Errorf("%w: %v.", ErrNoValues, parameter.Name)
+			}
+		}
+	}
+
+	return combinations, nil
+}
+
+// Copy of base with each dotted path set to its value
+func Apply(base Scenario.Scenario, parameters map[string]json.RawMessage) (Scenario.Scenario, error) {
+	data, err := json.Marshal(base)
+	if err != nil {
+		return base, err
+	}
+
+	var tree map[string]any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return base, err
+	}
+
+	for path, raw := range parameters {
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return base, fmt.Errorf("Invalid value for %v: %w.", path, err)
+		}
+
+		node := tree
+		keys := strings.Split(path, ".")
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := node[key].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				node[key] = child
+			}
+			node = child
+		}
+		node[keys[len(keys)-1]] = value
+	}
+
+	data, err = json.Marshal(tree)
+	if err != nil {
+		return base, err
+	}
+
+	var scenario Scenario.Scenario
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return base, fmt.Errorf("Parameters do not fit the scenario: %w.", err)
+	}
+
+	return scenario, scenario.Validate()
+}
+
+func withSeed(scenario Scenario.Scenario, seed int64) Scenario.Scenario {
+	scenario.Seed = seed
+	scenario.ContactSeed = seed
+	if scenario.UserOptions != nil {
+		options := *scenario.UserOptions
+		options.Seed = nil
+		scenario.UserOptions = &options
+	}
+	return scenario
+}
+
+// Builds or checks the base images once and runs every combination, rewriting the index after each run
+func (sweep Sweep) Run(dockerClient *client.Client) (*Index, error) {
+	combinations, err := sweep.Combinations()
+	if err != nil {
+		return nil, err
+	}
+
+	index := &Index{Started: time.Now(), Base: sweep.Base}
+	scenarios := []Scenario.Scenario{}
+	for _, combination := range combinations {
+		scenario, err := Apply(sweep.Base, combination)
+		if err != nil {
+			return nil, err
+		}
+		scenario.Unattended = true
+
+		if len(sweep.Seeds) == 0 {
+			index.Runs = append(index.Runs, Run{Number: len(index.Runs), Parameters: combination})
+			scenarios = append(scenarios, scenario)
+			continue
+		}
+
+		for _, seed := range sweep.Seeds {
+			index.Runs = append(index.Runs, Run{Number: len(index.Runs), Parameters: combination, Seed: &seed})
+			scenarios = append(scenarios, withSeed(scenario, seed))
+		}
+	}
+
+	indexPath := sweep.Index
+	if indexPath == "" {
+		indexPath = filepath.Join("logs", fmt.Sprintf("sweep-%v.json", index.Started.Format("2006-01-02_15-04-05")))
+	}
+
+	var mu sync.Mutex
+	if err := writeIndex(indexPath, index); err != nil {
+		return nil, err
+	}
+
+	if sweep.NoBuild {
+		err = sweep.Base.CheckImages(dockerClient)
+	} else {
+		err = sweep.Base.BuildImages(dockerClient, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parallel := max(sweep.Parallel, 1)
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, scenario := range scenarios {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, scenario Scenario.Scenario) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			fmt.Printf("Sweep run %d/%d\n", i+1, len(scenarios))
+			result, err := scenario.Run(dockerClient)
+
+			mu.Lock()
+			defer mu.Unlock()
+			run := &index.Runs[i]
+			run.Result = result
+			if result != nil {
+				run.Dir = result.Dir
+			}
+			if err != nil {
+				run.Error = err.Error()
+			}
+			if err := writeIndex(indexPath, index); err != nil {
+				fmt.Println(err)
+			}
+		}(i, scenario)
+	}
+	wg.Wait()
+
+	index.Finished = time.Now()
+	return index, writeIndex(indexPath, index)
+}
+
+func writeIndex(path string, index *Index) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Failed to create index directory: %w.", err)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Failed to write sweep index: %w.", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package Sweep
+
+import (
+	"encoding/json"
+	"testing"
+
+	Scenario "deniable-im/im-sim/pkg/simulation/scenario"
+	Types "deniable-im/im-sim/pkg/simulation/types"
+)
+
+func TestGridApply(t *testing.T) {
+	burstSize := 5
+	base := Scenario.DefaultDenimScenario()
+	base.UserOptions = &Types.SimUserOptions{BurstSize: &burstSize}
+
+	sweep := Sweep{
+		Base: base,
+		Parameters: []Parameter{
+			{Name: "UserCount", Values: []json.RawMessage{[]byte("10"), []byte("20")}},
+			{Name: "UserOptions.BurstSize", Values: []json.RawMessage{[]byte("3"), []byte("7"), []byte("9")}},
+		},
+	}
+
+	combinations, err := sweep.Combinations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(combinations) != 6 {
+		t.Fatalf("Expected 6 combinations, got %d", len(combinations))
+	}
+
+	scenario, err := Apply(base, combinations[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario.UserCount != 20 || *scenario.UserOptions.BurstSize != 9 {
+		t.Errorf("Parameters not applied: %d users, burst size %d", scenario.UserCount, *scenario.UserOptions.BurstSize)
+	}
+	if *base.UserOptions.BurstSize != 5 || scenario.ContactSeed != base.ContactSeed {
+		t.Errorf("Apply changed more than the parameters")
+	}
+}
+
+func TestApplyRejectsInvalidScenario(t *testing.T) {
+	base := Scenario.DefaultDenimScenario()
+	if _, err := Apply(base, map[string]json.RawMessage{"UserCount": []byte("0")}); err == nil {
+		t.Error("Expected a scenario without users to be rejected")
+	}
+}
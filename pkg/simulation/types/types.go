@@ -3,15 +3,52 @@ package Types
 import "time"
 
 type Msg struct {
+	ID         string `json:",omitempty"`
 	To, From   string
 	MsgContent string
 	IsDeniable bool
+	Attachment *Attachment `json:",omitempty"`
+}
+
+type AttachmentKind string
+
+const (
+	Image     AttachmentKind = "image"
+	VoiceNote AttachmentKind = "voice"
+	File      AttachmentKind = "file"
+)
+
+// Size is the attachment payload in bytes. Emulated attachments are sent as a padded text body.
+type Attachment struct {
+	Kind     AttachmentKind
+	Size     int
+	Emulated bool
+}
+
+// Probability of attaching media of Kind to a regular message. Sizes are drawn from a
+// log-normal distribution with the given median and spread (sigma of ln bytes), clamped to MaxSize.
+type MediaProfile struct {
+	Kind       AttachmentKind
+	Prob       float64
+	MedianSize int
+	SizeSigma  float64
+	MaxSize    int
 }
 
 type MsgEvent struct {
 	EventType string
 	Timestamp time.Time
 	Msg       Msg
+	Process   *ProcessEvent `json:",omitempty"`
+}
+
+// Exit of a client process, EventType is "Restart" or "Exit"
+type ProcessEvent struct {
+	Container string
+	Pid       int
+	ExitCode  int
+	Restarts  int
+	Error     string `json:",omitempty"`
 }
 
 type SimUser struct {
@@ -42,6 +79,9 @@ type SimUserOptions struct {
 	BurstModifier             *float64
 	BurstSize                 *int
 	Seed                      *int64
+	MediaProfiles             []MediaProfile
+	MinMaxMediaScale          *FloatTuple
+	ClientAttachments         bool // Use the client's attach command instead of padded text bodies, needs client support
 }
 
 func (options *SimUserOptions) HasNil() bool {
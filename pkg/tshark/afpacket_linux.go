@@ -0,0 +1,204 @@
+//go:build linux
+
+package tshark
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// How often a blocked read checks whether the capture was stopped
+const afPacketPollInterval = 200 * time.Millisecond
+
+// Socket receive buffer, absorbs bursts while a packet is written. Capped by net.core.rmem_max
+// without CAP_NET_ADMIN.
+const afPacketRcvBuf = 8 << 20
+
+// ARPHRD_NONE, used by tun and other interfaces without a link layer header
+const arphrdNone = "65534"
+
+func init() {
+	backends[BackendAFPacket] = afPacketBackend{}
+}
+
+// Reads packets from an AF_PACKET socket and writes pcapng itself. Needs CAP_NET_RAW,
+// e.g. `sudo setcap cap_net_raw+ep <binary>`, but no Wireshark. Only HostFilter capture
+// filters are supported and display filters are not.
+type afPacketBackend struct{}
+
+func (afPacketBackend) Start(networkInterfaceName, filename string, options *Options) (*Capture, error) {
+	if options.DisplayFilter != "" {
+		return nil, fmt.Errorf("%w: display filters need %v.", ErrUnsupported, BackendTshark)
+	}
+
+	hosts, err := parseHostFilter(options.CaptureFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	iface, err := net.InterfaceByName(networkInterfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find interface %v: %w.", networkInterfaceName, err)
+	}
+
+	linkType := LinkTypeEthernet
+	if arphrd, err := os.ReadFile(fmt.Sprintf("/sys/class/net/%v/type", networkInterfaceName)); err == nil && strings.TrimSpace(string(arphrd)) == arphrdNone {
+		linkType = LinkTypeRaw
+	}
+
+	fd, err := openPacketSocket(iface.Index)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to capture on %v: %w.", networkInterfaceName, err)
+	}
+
+	ring, err := newRingWriter(filename, *options, linkType, networkInterfaceName)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	var stopped atomic.Bool
+	capture := newCapture(networkInterfaceName, filename, options)
+	capture.interrupt = func() { stopped.Store(true) }
+
+	// A write stuck on the capture file fails once the file is closed
+	capture.kill = func() {
+		stopped.Store(true)
+		ring.abort()
+	}
+
+	go func() {
+		stats := &packetStats{}
+		err := record(fd, ring, linkType, hosts, iface.Flags&net.FlagLoopback != 0, &stopped, stats)
+		stats.read(fd)
+		syscall.Close(fd)
+		err = errors.Join(err, ring.Close())
+
+		if stats.drops > 0 {
+			capture.warn(fmt.Errorf("%w on %v: %d of %d packets.", ErrDropped, networkInterfaceName, stats.drops, stats.packets))
+		}
+
+		reason := "stopped"
+		if err != nil {
+			reason = err.Error()
+		}
+		capture.exit(reason)
+	}()
+
+	return capture, nil
+}
+
+// Raw socket receiving every protocol on the interface, in both directions
+func openPacketSocket(ifindex int) (int, error) {
+	protocol := htons(syscall.ETH_P_ALL)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, int(protocol))
+	if err != nil {
+		return -1, err
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: protocol, Ifindex: ifindex}); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	timeout := syscall.NsecToTimeval(afPacketPollInterval.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	// Stamped by the kernel on arrival rather than when the packet is read
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, afPacketRcvBuf); err != nil {
+		syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, afPacketRcvBuf)
+	}
+
+	return fd, nil
+}
+
+// Packets the socket received and dropped because the receive buffer was full
+type packetStats struct {
+	packets uint64
+	drops   uint64
+}
+
+// Adds the counters since the last read, the kernel resets them on every read
+func (stats *packetStats) read(fd int) {
+	tpacketStats, err := unix.GetsockoptTpacketStats(fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		return
+	}
+	stats.packets += uint64(tpacketStats.Packets)
+	stats.drops += uint64(tpacketStats.Drops)
+}
+
+// Kernel receive time from the SO_TIMESTAMPNS control message, the current time if there is none
+func packetTimestamp(oob []byte) time.Time {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Now()
+	}
+
+	for _, message := range messages {
+		if message.Header.Level != syscall.SOL_SOCKET || message.Header.Type != syscall.SCM_TIMESTAMPNS {
+			continue
+		}
+		if len(message.Data) < int(unsafe.Sizeof(syscall.Timespec{})) {
+			break
+		}
+		timestamp := (*syscall.Timespec)(unsafe.Pointer(&message.Data[0]))
+		return time.Unix(timestamp.Unix())
+	}
+	return time.Now()
+}
+
+// Copies packets to the ring writer until stopped is set. MSG_TRUNC makes recvmsg return
+// the length on the wire when a packet does not fit the buffer.
+func record(fd int, ring *ringWriter, linkType uint16, hosts map[netip.Addr]struct{}, loopback bool, stopped *atomic.Bool, stats *packetStats) error {
+	buffer := make([]byte, maxSnaplen)
+	oob := make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(syscall.Timespec{}))))
+	for !stopped.Load() {
+		n, oobn, _, from, err := syscall.Recvmsg(fd, buffer, oob, syscall.MSG_TRUNC)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				// Keeps the kernel's 32-bit counters from wrapping on long captures
+				stats.read(fd)
+				continue
+			}
+			return fmt.Errorf("Failed to read packet: %w.", err)
+		}
+
+		// Loopback delivers every packet a second time as incoming, like libpcap keep that copy only
+		if link, ok := from.(*syscall.SockaddrLinklayer); ok && loopback && link.Pkttype == syscall.PACKET_OUTGOING {
+			continue
+		}
+
+		packet := buffer[:min(n, len(buffer))]
+		if !matchHosts(packet, linkType, hosts) {
+			continue
+		}
+
+		if err := ring.writePacket(packetTimestamp(oob[:oobn]), packet, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func htons(value uint16) uint16 {
+	return value<<8 | value>>8
+}
@@ -0,0 +1,251 @@
+package tshark
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrExited         = errors.New("Capture exited before it was stopped")
+	ErrUnknownBackend = errors.New("Unknown capture backend")
+	ErrUnsupported    = errors.New("Capture option not supported by the backend")
+	ErrDropped        = errors.New("Capture dropped packets")
+	ErrNotStopped     = errors.New("Capture did not stop")
+)
+
+const (
+	BackendTshark   = "tshark"
+	BackendAFPacket = "afpacket" // Pure Go, Linux only, needs CAP_NET_RAW instead of the wireshark group
+)
+
+const stopTimeout = 10 * time.Second
+
+// Records an interface into pcapng files until the capture is stopped
+type Backend interface {
+	Start(networkInterfaceName, filename string, options *Options) (*Capture, error)
+}
+
+// Backends by name, platform specific ones register themselves
+var backends = map[string]Backend{
+	BackendTshark: tsharkBackend{},
+}
+
+// Zero values leave tshark's defaults: whole packets in a single uncompressed file
+type Options struct {
+	Backend       string // BackendTshark if empty
+	CaptureFilter string // BPF filter applied while capturing, e.g. HostFilter(...)
+	DisplayFilter string // Applied to the written files when the capture stops, packets not matching are dropped
+	Snaplen       int    // Bytes kept of each packet
+	RingFileSize  int    // Kilobytes per file before switching to the next
+	RingDuration  int    // Seconds per file before switching to the next
+	RingFiles     int    // Oldest files are deleted beyond this count, all are kept if 0
+	Compress      bool   // Gzip the files when the capture stops, tshark reads them as is
+}
+
+func (options *Options) ringBuffer() bool {
+	return options.RingFileSize > 0 || options.RingDuration > 0
+}
+
+// A running capture of one interface into filename, or into numbered files next to it
+// in ring buffer mode
+type Capture struct {
+	Interface string
+	Filename  string
+	options   Options
+	interrupt func() // Asks the backend to flush and finish
+	kill      func() // Ends the backend if it does not finish within stopTimeout
+	done      chan struct{}
+	mu        sync.Mutex
+	stopping  bool
+	err       error
+	warnings  []error // Problems with a capture that still finished, returned by Stop
+	files     []string
+}
+
+// Starts capturing with the backend named in options until Stop is called, nil options use the defaults
+func Start(networkInterfaceName, filename string, options *Options) (*Capture, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	name := options.Backend
+	if name == "" {
+		name = BackendTshark
+	}
+
+	backend, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q.", ErrUnknownBackend, name)
+	}
+	return backend.Start(networkInterfaceName, filename, options)
+}
+
+func newCapture(networkInterfaceName, filename string, options *Options) *Capture {
+	return &Capture{
+		Interface: networkInterfaceName,
+		Filename:  filename,
+		options:   *options,
+		done:      make(chan struct{}),
+	}
+}
+
+// Called by the backend once it stops recording, reason is why if it was not asked to
+func (capture *Capture) exit(reason string) {
+	capture.mu.Lock()
+	if !capture.stopping {
+		capture.err = fmt.Errorf("%w on %v: %v", ErrExited, capture.Interface, reason)
+	}
+	capture.mu.Unlock()
+
+	close(capture.done)
+}
+
+// Called by the backend before exit for problems that do not end the capture
+func (capture *Capture) warn(err error) {
+	capture.mu.Lock()
+	capture.warnings = append(capture.warnings, err)
+	capture.mu.Unlock()
+}
+
+// Closed when the backend stops recording
+func (capture *Capture) Done() <-chan struct{} {
+	return capture.done
+}
+
+// Why the capture ended early, nil while it runs or after a clean stop
+func (capture *Capture) Err() error {
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+	return capture.err
+}
+
+// Interrupts the backend so it flushes its files, then applies the display filter and compression
+func (capture *Capture) Stop() error {
+	capture.mu.Lock()
+	capture.stopping = true
+	capture.mu.Unlock()
+
+	select {
+	case <-capture.done:
+	default:
+		capture.interrupt()
+		select {
+		case <-capture.done:
+		case <-time.After(stopTimeout):
+			capture.kill()
+			select {
+			case <-capture.done:
+			case <-time.After(stopTimeout):
+				return fmt.Errorf("%w on %v within %v of being killed.", ErrNotStopped, capture.Interface, stopTimeout)
+			}
+		}
+	}
+
+	capture.mu.Lock()
+	errs := slices.Clone(capture.warnings)
+	capture.mu.Unlock()
+
+	files, err := capture.written()
+	if err != nil {
+		return errors.Join(append([]error{capture.Err(), err}, errs...)...)
+	}
+
+	if capture.options.DisplayFilter != "" {
+		for _, file := range files {
+			errs = append(errs, filterFile(file, capture.options.DisplayFilter))
+		}
+	}
+
+	if capture.options.Compress {
+		for i, file := range files {
+			compressed, err := compressFile(file)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			files[i] = compressed
+		}
+	}
+
+	capture.mu.Lock()
+	capture.files = files
+	capture.mu.Unlock()
+
+	return errors.Join(append([]error{capture.Err()}, errs...)...)
+}
+
+// Files written by the capture in order, final names after Stop
+func (capture *Capture) Files() ([]string, error) {
+	capture.mu.Lock()
+	files := capture.files
+	capture.mu.Unlock()
+
+	if files != nil {
+		return files, nil
+	}
+	return capture.written()
+}
+
+// Ring buffer files are named <stem>_<n>_<time><ext> like tshark does
+func ringFilename(filename string, n int, opened time.Time) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%v_%05d_%v%v", strings.TrimSuffix(filename, ext), n, opened.Format("20060102150405"), ext)
+}
+
+func (capture *Capture) written() ([]string, error) {
+	if !capture.options.ringBuffer() {
+		return []string{capture.Filename}, nil
+	}
+
+	ext := filepath.Ext(capture.Filename)
+	files, err := filepath.Glob(strings.TrimSuffix(capture.Filename, ext) + "_*" + ext)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list capture files: %w.", err)
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+func filterFile(filename, displayFilter string) error {
+	filtered := filename + ".filtered"
+	output, err := exec.Command("tshark", "-r", filename, "-Y", displayFilter, "-F", "pcapng", "-w", filtered).CombinedOutput()
+	if err != nil {
+		os.Remove(filtered)
+		return fmt.Errorf("Failed to filter %v: %w: %s", filename, err, output)
+	}
+	return os.Rename(filtered, filename)
+}
+
+func compressFile(filename string) (string, error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("Failed to compress %v: %w.", filename, err)
+	}
+	defer in.Close()
+
+	compressed := filename + ".gz"
+	out, err := os.Create(compressed)
+	if err != nil {
+		return "", fmt.Errorf("Failed to compress %v: %w.", filename, err)
+	}
+	defer out.Close()
+
+	writer := gzip.NewWriter(out)
+	if _, err := io.Copy(writer, in); err != nil {
+		return "", fmt.Errorf("Failed to compress %v: %w.", filename, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("Failed to compress %v: %w.", filename, err)
+	}
+
+	return compressed, os.Remove(filename)
+}
@@ -0,0 +1,39 @@
+package tshark
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+type DecodeOptions struct {
+	DisplayFilter string   // e.g. "tls.app_data", all packets if empty
+	Fields        []string // Printed with -T fields, e.g. "frame.time_epoch" and "tls.app_data", packet summaries if empty
+}
+
+// Reads a capture with the TLS session keys of an NSS key log file and returns tshark's output
+// for the decrypted packets
+func Decode(capture, keyLog string, options *DecodeOptions) ([]byte, error) {
+	if options == nil {
+		options = &DecodeOptions{}
+	}
+
+	args := []string{"-r", capture, "-o", fmt.Sprintf("tls.keylog_file:%v", keyLog)}
+	if options.DisplayFilter != "" {
+		args = append(args, "-Y", options.DisplayFilter)
+	}
+	if len(options.Fields) > 0 {
+		args = append(args, "-T", "fields")
+		for _, field := range options.Fields {
+			args = append(args, "-e", field)
+		}
+	}
+
+	output, err := exec.Command("tshark", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("Failed to decode %v: %w: %s", capture, err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("Failed to decode %v: %w.", capture, err)
+	}
+	return output, nil
+}
@@ -0,0 +1,102 @@
+package tshark
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Capture filter (BPF) matching traffic to or from any of the addresses, IPv4 or IPv6
+func HostFilter(addresses ...string) string {
+	var hosts []string
+	for _, address := range addresses {
+		if address != "" {
+			hosts = append(hosts, "host "+address)
+		}
+	}
+	return strings.Join(hosts, " or ")
+}
+
+// Display filter matching packets to or from any of the addresses, using ip.addr or ipv6.addr by family
+func AddressFilter(addresses ...string) string {
+	var fields []string
+	for _, address := range addresses {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			continue
+		}
+		if addr.Is6() && !addr.Is4In6() {
+			fields = append(fields, "ipv6.addr == "+addr.String())
+		} else {
+			fields = append(fields, "ip.addr == "+addr.Unmap().String())
+		}
+	}
+	return strings.Join(fields, " || ")
+}
+
+// Addresses of a capture filter written by HostFilter, for backends that cannot compile BPF
+func parseHostFilter(filter string) (map[netip.Addr]struct{}, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+
+	hosts := make(map[netip.Addr]struct{})
+	for _, term := range strings.Split(filter, " or ") {
+		address, ok := strings.CutPrefix(strings.TrimSpace(term), "host ")
+		if !ok {
+			return nil, fmt.Errorf("%w: capture filter %q is not a HostFilter.", ErrUnsupported, filter)
+		}
+		addr, err := netip.ParseAddr(strings.TrimSpace(address))
+		if err != nil {
+			return nil, fmt.Errorf("%w: capture filter %q: %w", ErrUnsupported, filter, err)
+		}
+		hosts[addr.Unmap()] = struct{}{}
+	}
+	return hosts, nil
+}
+
+// Whether the packet's IPv4 or IPv6 source or destination is one of the hosts, all packets match if hosts is nil
+func matchHosts(packet []byte, linkType uint16, hosts map[netip.Addr]struct{}) bool {
+	if hosts == nil {
+		return true
+	}
+
+	header := packet
+	if linkType == LinkTypeEthernet {
+		if len(packet) < 14 {
+			return false
+		}
+		etherType := binary.BigEndian.Uint16(packet[12:14])
+		header = packet[14:]
+		if etherType == 0x8100 && len(packet) >= 18 { // 802.1Q tag
+			header = packet[18:]
+		}
+	}
+
+	if len(header) == 0 {
+		return false
+	}
+
+	var src, dst netip.Addr
+	switch header[0] >> 4 {
+	case 4:
+		if len(header) < 20 {
+			return false
+		}
+		src, _ = netip.AddrFromSlice(header[12:16])
+		dst, _ = netip.AddrFromSlice(header[16:20])
+	case 6:
+		if len(header) < 40 {
+			return false
+		}
+		src, _ = netip.AddrFromSlice(header[8:24])
+		dst, _ = netip.AddrFromSlice(header[24:40])
+	default:
+		return false
+	}
+
+	_, srcMatch := hosts[src]
+	_, dstMatch := hosts[dst]
+	return srcMatch || dstMatch
+}
@@ -0,0 +1,44 @@
+package tshark
+
+import "testing"
+
+func TestDualStackFilters(t *testing.T) {
+	if filter := HostFilter("10.10.248.3", "fd00:10:0:0::3", ""); filter != "host 10.10.248.3 or host fd00:10:0:0::3" {
+		t.Errorf("Unexpected capture filter %q", filter)
+	}
+
+	if filter := AddressFilter("10.10.248.3", "fd00:10:0:0::3"); filter != "ip.addr == 10.10.248.3 || ipv6.addr == fd00:10::3" {
+		t.Errorf("Unexpected display filter %q", filter)
+	}
+}
+
+func TestMatchHosts(t *testing.T) {
+	hosts, err := parseHostFilter(HostFilter("10.10.248.3", "fd00:10::3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipv4 := make([]byte, 14+20)
+	ipv4[12], ipv4[13], ipv4[14] = 0x08, 0x00, 0x45
+	copy(ipv4[14+12:], []byte{10, 10, 248, 3})
+	copy(ipv4[14+16:], []byte{10, 10, 240, 2})
+	if !matchHosts(ipv4, LinkTypeEthernet, hosts) {
+		t.Error("IPv4 packet from a filtered host did not match")
+	}
+
+	ipv6 := make([]byte, 40)
+	ipv6[0] = 0x60
+	copy(ipv6[24:], []byte{0xfd, 0, 0, 0x10, 14: 0, 15: 3})
+	if !matchHosts(ipv6, LinkTypeRaw, hosts) {
+		t.Error("IPv6 packet to a filtered host did not match")
+	}
+
+	copy(ipv4[14+12:], []byte{10, 10, 248, 4})
+	if matchHosts(ipv4, LinkTypeEthernet, hosts) {
+		t.Error("Packet between other hosts matched")
+	}
+
+	if _, err := parseHostFilter("tcp port 443"); err == nil {
+		t.Error("Expected an error for a filter that is not a HostFilter")
+	}
+}
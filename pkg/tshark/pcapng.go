@@ -0,0 +1,220 @@
+package tshark
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Link types of the interface description block
+const (
+	LinkTypeEthernet uint16 = 1
+	LinkTypeRaw      uint16 = 101 // Packets start at the IP header
+)
+
+const (
+	blockSectionHeader        = 0x0A0D0D0A
+	blockInterfaceDescription = 0x00000001
+	blockEnhancedPacket       = 0x00000006
+	byteOrderMagic            = 0x1A2B3C4D
+
+	optEndOfOpt   = 0
+	optUserAppl   = 4 // shb_userappl
+	optIfName     = 2 // if_name
+	optIfTsresol  = 9 // if_tsresol
+	tsresolNanos  = 9 // 10^-9 seconds
+	maxSnaplen    = 262144
+	blockOverhead = 12 // Type and both total lengths
+)
+
+// Writes one pcapng section with a single interface, in host (little endian) byte order
+type pcapngWriter struct {
+	w       io.Writer
+	snaplen int
+	written int64
+}
+
+func newPcapngWriter(w io.Writer, linkType uint16, snaplen int, interfaceName string) (*pcapngWriter, error) {
+	if snaplen <= 0 || snaplen > maxSnaplen {
+		snaplen = maxSnaplen
+	}
+	writer := &pcapngWriter{w: w, snaplen: snaplen}
+
+	var header []byte
+	header = binary.LittleEndian.AppendUint32(header, byteOrderMagic)
+	header = binary.LittleEndian.AppendUint16(header, 1)          // Major version
+	header = binary.LittleEndian.AppendUint16(header, 0)          // Minor version
+	header = binary.LittleEndian.AppendUint64(header, ^uint64(0)) // Section length unknown
+	header = appendOption(header, optUserAppl, []byte("im-sim"))
+	header = appendOption(header, optEndOfOpt, nil)
+	if err := writer.block(blockSectionHeader, header); err != nil {
+		return nil, err
+	}
+
+	var description []byte
+	description = binary.LittleEndian.AppendUint16(description, linkType)
+	description = binary.LittleEndian.AppendUint16(description, 0) // Reserved
+	description = binary.LittleEndian.AppendUint32(description, uint32(snaplen))
+	description = appendOption(description, optIfName, []byte(interfaceName))
+	description = appendOption(description, optIfTsresol, []byte{tsresolNanos})
+	description = appendOption(description, optEndOfOpt, nil)
+	if err := writer.block(blockInterfaceDescription, description); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// Writes data, truncated to the snap length, as a packet of length bytes on the wire
+func (writer *pcapngWriter) writePacket(timestamp time.Time, data []byte, length int) error {
+	if len(data) > writer.snaplen {
+		data = data[:writer.snaplen]
+	}
+
+	ns := uint64(timestamp.UnixNano())
+	body := make([]byte, 0, 20+len(data)+3)
+	body = binary.LittleEndian.AppendUint32(body, 0) // Interface ID
+	body = binary.LittleEndian.AppendUint32(body, uint32(ns>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ns))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(length))
+	body = append(body, data...)
+	body = pad(body)
+
+	return writer.block(blockEnhancedPacket, body)
+}
+
+func (writer *pcapngWriter) block(blockType uint32, body []byte) error {
+	total := uint32(len(body) + blockOverhead)
+
+	block := make([]byte, 0, total)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, total)
+
+	n, err := writer.w.Write(block)
+	writer.written += int64(n)
+	if err != nil {
+		return fmt.Errorf("Failed to write pcapng block: %w.", err)
+	}
+	return nil
+}
+
+func appendOption(options []byte, code uint16, value []byte) []byte {
+	options = binary.LittleEndian.AppendUint16(options, code)
+	options = binary.LittleEndian.AppendUint16(options, uint16(len(value)))
+	return pad(append(options, value...))
+}
+
+// Pads to a 32-bit boundary
+func pad(data []byte) []byte {
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// Writes packets to filename, or rotates through ring buffer files named like tshark's
+type ringWriter struct {
+	filename      string
+	options       Options
+	linkType      uint16
+	interfaceName string
+
+	file   *os.File
+	buffer *bufio.Writer
+	writer *pcapngWriter
+	opened time.Time
+	count  int
+	files  []string
+
+	mu      sync.Mutex // Guards file against abort from another goroutine
+	aborted bool
+}
+
+func newRingWriter(filename string, options Options, linkType uint16, interfaceName string) (*ringWriter, error) {
+	ring := &ringWriter{filename: filename, options: options, linkType: linkType, interfaceName: interfaceName}
+	if err := ring.open(time.Now()); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func (ring *ringWriter) open(now time.Time) error {
+	filename := ring.filename
+	if ring.options.ringBuffer() {
+		ring.count++
+		filename = ringFilename(ring.filename, ring.count, now)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to create capture file: %w.", err)
+	}
+
+	ring.mu.Lock()
+	if ring.aborted {
+		ring.mu.Unlock()
+		file.Close()
+		os.Remove(filename)
+		return fmt.Errorf("Failed to create capture file: %w.", os.ErrClosed)
+	}
+	ring.file = file
+	ring.mu.Unlock()
+
+	ring.buffer, ring.opened = bufio.NewWriter(file), now
+	ring.writer, err = newPcapngWriter(ring.buffer, ring.linkType, ring.options.Snaplen, ring.interfaceName)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	ring.files = append(ring.files, filename)
+	if ring.options.RingFiles > 0 && len(ring.files) > ring.options.RingFiles {
+		os.Remove(ring.files[0])
+		ring.files = ring.files[1:]
+	}
+	return nil
+}
+
+// Switches to the next file first if the current one is full or old enough
+func (ring *ringWriter) writePacket(timestamp time.Time, data []byte, length int) error {
+	if ring.full(timestamp) {
+		if err := ring.Close(); err != nil {
+			return err
+		}
+		if err := ring.open(timestamp); err != nil {
+			return err
+		}
+	}
+	return ring.writer.writePacket(timestamp, data, length)
+}
+
+func (ring *ringWriter) full(now time.Time) bool {
+	if ring.options.RingFileSize > 0 && ring.writer.written >= int64(ring.options.RingFileSize)*1000 {
+		return true
+	}
+	return ring.options.RingDuration > 0 && now.Sub(ring.opened) >= time.Duration(ring.options.RingDuration)*time.Second
+}
+
+// Closes the current file without flushing, so a blocked or later write fails
+func (ring *ringWriter) abort() {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	ring.aborted = true
+	ring.file.Close()
+}
+
+func (ring *ringWriter) Close() error {
+	if err := ring.buffer.Flush(); err != nil {
+		ring.file.Close()
+		return fmt.Errorf("Failed to write capture file: %w.", err)
+	}
+	return ring.file.Close()
+}
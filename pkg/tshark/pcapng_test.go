@@ -0,0 +1,44 @@
+package tshark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestPcapngBlocks(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, err := newPcapngWriter(&buffer, LinkTypeEthernet, 64, "dm-0123456789ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.writePacket(time.Unix(1, 5), make([]byte, 100), 1500); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buffer.Bytes()
+	var types []uint32
+	for len(data) > 0 {
+		blockType := binary.LittleEndian.Uint32(data)
+		total := binary.LittleEndian.Uint32(data[4:])
+		if total%4 != 0 || int(total) > len(data) || binary.LittleEndian.Uint32(data[total-4:]) != total {
+			t.Fatalf("Malformed block %#x of length %d", blockType, total)
+		}
+
+		if blockType == blockEnhancedPacket {
+			timestamp := uint64(binary.LittleEndian.Uint32(data[12:]))<<32 | uint64(binary.LittleEndian.Uint32(data[16:]))
+			captured, length := binary.LittleEndian.Uint32(data[20:]), binary.LittleEndian.Uint32(data[24:])
+			if timestamp != 1e9+5 || captured != 64 || length != 1500 {
+				t.Errorf("Got timestamp %d, captured %d, length %d", timestamp, captured, length)
+			}
+		}
+
+		types = append(types, blockType)
+		data = data[total:]
+	}
+
+	if len(types) != 3 || types[0] != blockSectionHeader || types[1] != blockInterfaceDescription {
+		t.Errorf("Unexpected blocks %#x", types)
+	}
+}
@@ -0,0 +1,87 @@
+package tshark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	CapturesDir   = "captures"
+	splitPoolSize = 8
+)
+
+// Writes dir/<name>.pcapng for each entry of hosts, holding the packets of the capture files
+// to or from any of that entry's addresses
+func SplitCapture(captures []string, dir string, hosts map[string][]string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create capture directory: %w.", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	sem := make(chan struct{}, splitPoolSize)
+	for name, addresses := range hosts {
+		filter := AddressFilter(addresses...)
+		if filter == "" {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			filename := filepath.Join(dir, name+".pcapng")
+			output, err := readCaptures(captures, "-Y", filter, "-F", "pcapng", "-w", filename)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("Failed to split capture for %v: %w: %s", name, err, output))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Runs tshark with args on the capture files, merged in timestamp order by mergecap if there are several
+func readCaptures(captures []string, args ...string) ([]byte, error) {
+	if len(captures) == 1 {
+		return exec.Command("tshark", append([]string{"-r", captures[0]}, args...)...).CombinedOutput()
+	}
+
+	merge := exec.Command("mergecap", append([]string{"-F", "pcapng", "-w", "-"}, captures...)...)
+	read := exec.Command("tshark", append([]string{"-r", "-"}, args...)...)
+
+	pipeReader, pipeWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	var mergeOutput, output bytes.Buffer
+	merge.Stdout, merge.Stderr = pipeWriter, &mergeOutput
+	read.Stdin, read.Stdout, read.Stderr = pipeReader, &output, &output
+
+	mergeErr := merge.Start()
+	pipeWriter.Close()
+	if mergeErr != nil {
+		pipeReader.Close()
+		return nil, mergeErr
+	}
+
+	readErr := read.Run()
+	pipeReader.Close()
+	mergeErr = merge.Wait()
+
+	output.Write(mergeOutput.Bytes())
+	return output.Bytes(), errors.Join(readErr, mergeErr)
+}
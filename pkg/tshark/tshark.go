@@ -6,27 +6,70 @@ import (
 	"os/exec"
 )
 
-func RunTshark(networkInterfaceName, dir string, duration int64) (*exec.Cmd, error) {
-	filename := fmt.Sprintf("%v/capture.pcapng", dir)
-	file, err := os.Create(filename)
-	if err != nil {
-		return nil, err
+// Runs the tshark binary, which needs the user in the wireshark group. Its stderr is kept in <filename>.log.
+type tsharkBackend struct{}
+
+func (options *Options) args() []string {
+	var args []string
+	if options.CaptureFilter != "" {
+		args = append(args, "-f", options.CaptureFilter)
+	}
+	if options.Snaplen > 0 {
+		args = append(args, "-s", fmt.Sprint(options.Snaplen))
+	}
+	if options.RingFileSize > 0 {
+		args = append(args, "-b", fmt.Sprintf("filesize:%v", options.RingFileSize))
+	}
+	if options.RingDuration > 0 {
+		args = append(args, "-b", fmt.Sprintf("duration:%v", options.RingDuration))
+	}
+	if options.RingFiles > 0 && options.ringBuffer() {
+		args = append(args, "-b", fmt.Sprintf("files:%v", options.RingFiles))
+	}
+	return args
+}
+
+func (tsharkBackend) Start(networkInterfaceName, filename string, options *Options) (*Capture, error) {
+	if !options.ringBuffer() {
+		file, err := os.Create(filename)
+		if err != nil {
+			return nil, err
+		}
+		file.Close() //intentional close as the file simply has to exist for tshark to use it.
+
+		if err := os.Chmod(filename, 0666); err != nil {
+			return nil, fmt.Errorf("Failed to set capture permissions: %w.", err)
+		}
 	}
-	file.Close() //intentional close as the file simply has to exist for tshark to use it.
 
-	chmodErr := os.Chmod(filename, 0666)
-	if chmodErr != nil {
-		fmt.Println("Error setting permissions:", chmodErr)
-		return nil, chmodErr
+	stderr, err := os.Create(filename + ".log")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create tshark log: %w.", err)
 	}
 
-	cmd := exec.Command("tshark", "-i", networkInterfaceName, "-a", fmt.Sprintf("duration:%v", duration), "-F", "pcapng", "-w", filename)
+	args := append([]string{"-i", networkInterfaceName, "-F", "pcapng", "-w", filename}, options.args()...)
+	cmd := exec.Command("tshark", args...)
+	cmd.Stderr = stderr
 
-	cmdErr := cmd.Start()
-	if cmdErr != nil {
-		fmt.Printf("Encountered error while starting tshark: %v", cmdErr)
-		return nil, cmdErr
+	if err := cmd.Start(); err != nil {
+		stderr.Close()
+		return nil, fmt.Errorf("Failed to start tshark on %v: %w.", networkInterfaceName, err)
 	}
 
-	return cmd, nil
+	capture := newCapture(networkInterfaceName, filename, options)
+	capture.interrupt = func() { cmd.Process.Signal(os.Interrupt) }
+	capture.kill = func() { cmd.Process.Kill() }
+
+	go func() {
+		err := cmd.Wait()
+		stderr.Close()
+
+		reason := "exit status 0"
+		if err != nil {
+			reason = err.Error()
+		}
+		capture.exit(fmt.Sprintf("tshark %v, see %v", reason, stderr.Name()))
+	}()
+
+	return capture, nil
 }
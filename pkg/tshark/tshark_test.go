@@ -0,0 +1,50 @@
+package tshark
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOptionsArgs(t *testing.T) {
+	options := Options{CaptureFilter: "host 10.10.248.3", Snaplen: 128, RingFileSize: 1024, RingFiles: 4}
+	want := []string{"-f", "host 10.10.248.3", "-s", "128", "-b", "filesize:1024", "-b", "files:4"}
+	if args := options.args(); !slices.Equal(args, want) {
+		t.Errorf("Got %q, want %q", args, want)
+	}
+
+	// A file limit without a ring buffer would make tshark refuse to start
+	if args := (&Options{RingFiles: 4}).args(); len(args) != 0 {
+		t.Errorf("Got %q without a ring buffer", args)
+	}
+}
+
+func TestRingBufferFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"capture_00002_20261019120500.pcapng", "capture_00001_20261019120000.pcapng", "capture.pcapng.log", "backend_00001_20261019120000.pcapng"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("pcapng"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	capture := &Capture{Filename: filepath.Join(dir, "capture.pcapng"), options: Options{RingDuration: 300, Compress: true}}
+	files, err := capture.written()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || filepath.Base(files[0]) != "capture_00001_20261019120000.pcapng" {
+		t.Fatalf("Unexpected ring buffer files %v", files)
+	}
+
+	compressed, err := compressFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+		t.Errorf("Uncompressed file %v was kept", files[0])
+	}
+	if filepath.Ext(compressed) != ".gz" {
+		t.Errorf("Unexpected compressed name %v", compressed)
+	}
+}